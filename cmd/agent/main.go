@@ -0,0 +1,80 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/ctriple/drbd/pkg/agent"
+	"github.com/ctriple/drbd/pkg/defs"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	interval    = flag.Duration("interval", 30*time.Second, "How often drbd resources on this node are checked")
+	fenceSocket = flag.String("fence-socket", defs.AgentFenceSocket, "Unix socket serving DRBD fence-peer handler, the flexvolume driver connects to the default")
+	metrics     = flag.String("metrics-address", ":9942", "Address serving prometheus metrics, empty to disable")
+
+	verifyInterval = flag.Duration("verify-interval", 7*24*time.Hour, "How often every resource is online verified, 0 to disable")
+	verifyRate     = flag.String("verify-rate", "", "Resync rate limit while online verify runs, such as 20M, the resource resync rate if empty")
+	verifyResync   = flag.Bool("verify-resync", false, "Resync data found out of sync by online verify, otherwise it is only reported")
+
+	gcInterval = flag.Duration("gc-interval", 10*time.Minute, "How often orphaned disks, resource files and resources on this node are looked for, 0 to disable")
+	gcRemove   = flag.Bool("gc-remove", false, "Remove orphans found in two gc rounds in a row, otherwise they are only reported")
+	gcDryRun   = flag.Bool("gc-dry-run", false, "Only log orphans which gc-remove would remove")
+
+	driftInterval = flag.Duration("drift-interval", 5*time.Minute, "How often resource files and running resources on this node are checked for drift from spec, 0 to disable")
+	namespace     = flag.String("namespace", os.Getenv("MY_POD_NAMESPACE"), "Namespace of the provisioner, where the peer Secrets are")
+)
+
+func main() {
+	flag.Parse()
+
+	node := os.Getenv("MY_NODE_NAME")
+	if node == "" {
+		glog.Fatalln("env MY_NODE_NAME not set!")
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		glog.Fatalf("Failed to create config: %v", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		glog.Fatalf("Failed to create client: %v", err)
+	}
+
+	a := agent.NewAgent(clientset, node)
+	a.EnableVerify(*verifyInterval, *verifyRate, *verifyResync)
+	a.EnableDrift(*driftInterval, *namespace)
+
+	go func() {
+		glog.Fatalln(a.ServeFence(*fenceSocket))
+	}()
+
+	if *metrics != "" {
+		prometheus.MustRegister(a.Collector())
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		go func() {
+			glog.Fatalln(http.ListenAndServe(*metrics, mux))
+		}()
+	}
+
+	if *gcInterval > 0 {
+		go a.RunGC(*gcInterval, *gcRemove, *gcDryRun, wait.NeverStop)
+	}
+
+	a.Run(*interval, wait.NeverStop)
+}
@@ -32,6 +32,9 @@ Action list:
   unmountdeivce   Not Supported
   mount
   umount
+  fence-peer      DRBD handler
+  unfence-peer    DRBD handler
+  split-brain     DRBD handler
 
 More FlexVolume Specification:
 
@@ -0,0 +1,135 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ctriple/drbd/pkg/agent"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const usage = `Usage: kubectl drbd command [flags] [args]
+
+Inspect and operate ctriple.cn/drbd volumes, operations are run by the node
+agent of the given node.
+
+Command list:
+  status     [pvc]               Status of pvc on all its nodes, or of all volumes
+  promote    pvc node            Make the volume Primary on node
+  demote     pvc node            Make the volume Secondary on node
+  connect    pvc node [peer]     Connect node to peer, or all peers
+  disconnect pvc node [peer]     Disconnect node from peer, or all peers
+  verify     pvc node [peer]     Online verify node against peer, or all peers
+  invalidate pvc node            Discard data on node, fully resync from peers
+  move       pvc from to         Move the replica on node from to node to
+
+Moving a replica recreates its pv with the new node affinity, the pvc stays
+bound. The replica on from is removed, the one on to is resynced from the
+peers, so all replicas must be in sync and from must not be Primary.
+
+Flags:
+`
+
+var (
+	flags      = flag.NewFlagSet("kubectl-drbd", flag.ExitOnError)
+	kubeconfig = flags.String("kubeconfig", "", "Path to the kubeconfig file, the kubectl defaults if empty")
+	namespace  = flags.String("n", "", "Namespace of the pvc, the current context namespace if empty")
+	timeout    = flags.Duration("timeout", 2*time.Minute, "How long to wait for the node agent to run an operation")
+	address    = flags.String("address", "", "Replication addresses of the node a replica moves to, comma separated, one for each path; like those of the node it moves from if empty")
+)
+
+func main() {
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	if len(os.Args) < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	command := os.Args[1]
+	flags.Parse(os.Args[2:])
+	args := flags.Args()
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = *kubeconfig
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{})
+
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		fatal("%v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		fatal("%v", err)
+	}
+	if *namespace == "" {
+		if *namespace, _, err = clientConfig.Namespace(); err != nil {
+			fatal("%v", err)
+		}
+	}
+
+	switch command {
+	case "status":
+		switch len(args) {
+		case 0:
+			err = statusAll(clientset)
+		case 1:
+			err = status(clientset, *namespace, args[0])
+		default:
+			flags.Usage()
+			os.Exit(2)
+		}
+
+	case agent.OpPromote, agent.OpDemote, agent.OpInvalidate:
+		if len(args) != 2 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		err = operate(clientset, *namespace, args[0], agent.Operation{Op: command, Node: args[1]}, *timeout)
+
+	case agent.OpConnect, agent.OpDisconnect, agent.OpVerify:
+		if len(args) != 2 && len(args) != 3 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		op := agent.Operation{Op: command, Node: args[1]}
+		if len(args) == 3 {
+			op.Peer = args[2]
+		}
+		err = operate(clientset, *namespace, args[0], op, *timeout)
+
+	case "move":
+		if len(args) != 3 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		var addrs []string
+		if *address != "" {
+			addrs = strings.Split(*address, ",")
+		}
+		err = move(clientset, *namespace, args[0], args[1], args[2], addrs, *timeout)
+
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fatal("%v", err)
+	}
+}
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "error: "+format+"\n", args...)
+	os.Exit(1)
+}
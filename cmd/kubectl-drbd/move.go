@@ -0,0 +1,284 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ctriple/drbd/pkg/agent"
+	"github.com/ctriple/drbd/pkg/crd"
+	"github.com/ctriple/drbd/pkg/defs"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// The finalizer kubernetes keeps on a pv while it is bound
+const pvProtectionFinalizer = "kubernetes.io/pv-protection"
+
+// move moves the replica of the resource of pvc from one node to another. The
+// DrbdResource takes the new node in place of the old one with the same node
+// id, the pv is recreated with the new node affinity, the pvc binds it again,
+// and the agent on the old node removes its replica. The agent on the new node
+// then recreates the backing disk and resyncs it from the peers, as after a
+// disk replacement, once the peers connect to it on their next drift check.
+//
+// addrs are the replication addresses of the new node, one for each path,
+// found like those of the old node if empty.
+func move(client kubernetes.Interface, namespace, pvcName, from, to string, addrs []string, timeout time.Duration) error {
+	pv, err := volume(client, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+	if pv.Status.Phase != v1.VolumeBound || pv.DeletionTimestamp != nil {
+		return fmt.Errorf("%s is %s", pv.Name, pv.Status.Phase)
+	}
+	if pending := pv.Annotations[defs.AnnOperation]; pending != "" {
+		return fmt.Errorf("%s has a pending operation: %s", pv.Name, pending)
+	}
+	if rejoin := pv.Annotations[defs.AnnRejoin]; rejoin != "" {
+		return fmt.Errorf("%s is rejoining: %s", pv.Name, rejoin)
+	}
+
+	r, err := crd.Get(client.CoreV1().RESTClient(), pv.Name)
+	if err != nil {
+		return err
+	}
+	i := -1
+	for j, n := range r.Spec.Nodes {
+		if n.Name == to {
+			return fmt.Errorf("%s is already a node of %s", to, pv.Name)
+		}
+		if n.Name == from && !n.Diskless {
+			i = j
+		}
+	}
+	if i < 0 {
+		return fmt.Errorf("%s is not a replica of %s", from, pv.Name)
+	}
+
+	// Every other replica must have all the data, the one moved away is
+	// gone before the new one is in sync.
+	for _, n := range r.Spec.Nodes {
+		if n.Diskless {
+			continue
+		}
+		status, ok := r.Status.Nodes[n.Name]
+		if !ok || !status.InSync {
+			return fmt.Errorf("%s is not in sync on %s", pv.Name, n.Name)
+		}
+		if n.Name == from && status.Role == "Primary" {
+			return fmt.Errorf("%s is Primary on %s, stop its pod first", pv.Name, from)
+		}
+	}
+
+	if len(addrs) == 0 {
+		if addrs, err = replicationAddresses(client, r.Spec.Nodes[i], to); err != nil {
+			return fmt.Errorf("%v, set -address", err)
+		}
+	}
+	if n := len(strings.Split(r.Spec.Nodes[i].Address, ",")); len(addrs) != n {
+		return fmt.Errorf("%s replicates over %d paths, got %d addresses", pv.Name, n, len(addrs))
+	}
+
+	// The node affinity of a pv is immutable, it is created again
+	moved := pv.DeepCopy()
+	moved.ObjectMeta = metav1.ObjectMeta{
+		Name:        pv.Name,
+		Labels:      moved.Labels,
+		Annotations: moved.Annotations,
+	}
+	moved.Status = v1.PersistentVolumeStatus{}
+	for _, term := range moved.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		for _, expr := range term.MatchExpressions {
+			for k, v := range expr.Values {
+				if v == from {
+					expr.Values[k] = to
+				}
+			}
+		}
+	}
+	leave := agent.Operation{Op: agent.OpLeave, Node: from}
+	data, err := json.Marshal(leave)
+	if err != nil {
+		return err
+	}
+	moved.Annotations[defs.AnnOperation] = string(data)
+
+	node := r.Spec.Nodes[i]
+	node.Name = to
+	node.Address = strings.Join(addrs, ",")
+	if err := replaceNode(client, pv.Name, from, node); err != nil {
+		return err
+	}
+	fmt.Printf("%s: node %d is %s in place of %s\n", pv.Name, node.NodeID, to, from)
+
+	if err := deleteVolume(client, pv, timeout); err != nil {
+		// The pv is still there, put the spec back
+		replaceNode(client, pv.Name, to, r.Spec.Nodes[i])
+		return err
+	}
+	if _, err := client.CoreV1().PersistentVolumes().Create(moved); err != nil {
+		out, _ := json.MarshalIndent(moved, "", "  ")
+		fmt.Fprintf(os.Stderr, "%s\n", out)
+		return fmt.Errorf("%s deleted but not created again, create it as above: %v", pv.Name, err)
+	}
+	fmt.Printf("%s: created with node affinity %s in place of %s\n", pv.Name, to, from)
+
+	if err := awaitResult(client, pv.Name, leave, timeout); err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s rejoins and resyncs from the peers, see kubectl drbd status\n", pv.Name, to)
+
+	return nil
+}
+
+// replaceNode replaces node name of resource with node, retried on conflict
+// with the status updates of the agents.
+func replaceNode(client kubernetes.Interface, resName, name string, node crd.DrbdNode) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		r, err := crd.Get(client.CoreV1().RESTClient(), resName)
+		if err != nil {
+			return err
+		}
+		for i := range r.Spec.Nodes {
+			if r.Spec.Nodes[i].Name == name {
+				r.Spec.Nodes[i] = node
+			}
+		}
+		delete(r.Status.Nodes, name)
+		return crd.Update(client.CoreV1().RESTClient(), r)
+	})
+}
+
+// deleteVolume deletes pv while it stays bound, the pvc is lost until the pv
+// is created again. The pv is never released, so it is not deleted by the
+// provisioner.
+func deleteVolume(client kubernetes.Interface, pv *v1.PersistentVolume, timeout time.Duration) error {
+	pvClient := client.CoreV1().PersistentVolumes()
+
+	uid := pv.UID
+	if err := pvClient.Delete(pv.Name, &metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}}); err != nil {
+		return err
+	}
+
+	return wait.PollImmediate(time.Second, timeout, func() (bool, error) {
+		latest, err := pvClient.Get(pv.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		var finalizers []string
+		for _, f := range latest.Finalizers {
+			if f != pvProtectionFinalizer {
+				finalizers = append(finalizers, f)
+			}
+		}
+		if len(finalizers) < len(latest.Finalizers) {
+			latest.Finalizers = finalizers
+			if _, err := pvClient.Update(latest); err != nil && !apierrors.IsConflict(err) {
+				return false, err
+			}
+		}
+		return false, nil
+	})
+}
+
+// replicationAddresses returns the addresses of node to for the paths of the
+// replica on node from: those set on to by hand, or for each path the address
+// of the same interface on to, or its InternalIP if from replicates over its
+// InternalIP. This is how the provisioner chose the addresses of from.
+func replicationAddresses(client kubernetes.Interface, from crd.DrbdNode, to string) ([]string, error) {
+	toNode, err := client.CoreV1().Nodes().Get(to, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if addrs := toNode.Annotations[defs.AnnReplicationAddress]; addrs != "" {
+		return strings.Split(addrs, ","), nil
+	}
+	fromNode, err := client.CoreV1().Nodes().Get(from.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	for _, a := range strings.Split(from.Address, ",") {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			return nil, fmt.Errorf("%s: invalid address %q", from.Name, a)
+		}
+
+		var found net.IP
+		if iface, ok := onInterface(fromNode, ip); ok {
+			found = interfaceAddress(toNode, iface, ip.To4() != nil)
+		} else if isInternalIP(fromNode, ip) {
+			found = internalIP(toNode, ip.To4() != nil)
+		}
+		if found == nil {
+			return nil, fmt.Errorf("no replication address on %s like %s of %s", to, a, from.Name)
+		}
+		addrs = append(addrs, found.String())
+	}
+
+	return addrs, nil
+}
+
+// onInterface returns the interface of ip published by the agent on node
+func onInterface(node *v1.Node, ip net.IP) (string, bool) {
+	for _, a := range strings.Split(node.Annotations[defs.AnnAddresses], ",") {
+		kv := strings.SplitN(a, "=", 2)
+		if len(kv) == 2 && ip.Equal(net.ParseIP(kv[1])) {
+			return kv[0], true
+		}
+	}
+	return "", false
+}
+
+// interfaceAddress returns the first address of iface on node of the family
+func interfaceAddress(node *v1.Node, iface string, ipv4 bool) net.IP {
+	for _, a := range strings.Split(node.Annotations[defs.AnnAddresses], ",") {
+		kv := strings.SplitN(a, "=", 2)
+		if len(kv) < 2 || kv[0] != iface {
+			continue
+		}
+		if ip := net.ParseIP(kv[1]); ip != nil && (ip.To4() != nil) == ipv4 {
+			return ip
+		}
+	}
+	return nil
+}
+
+func isInternalIP(node *v1.Node, ip net.IP) bool {
+	for _, a := range node.Status.Addresses {
+		if a.Type == v1.NodeInternalIP && ip.Equal(net.ParseIP(a.Address)) {
+			return true
+		}
+	}
+	return false
+}
+
+// internalIP returns the first InternalIP of node of the family
+func internalIP(node *v1.Node, ipv4 bool) net.IP {
+	for _, a := range node.Status.Addresses {
+		if a.Type != v1.NodeInternalIP {
+			continue
+		}
+		if ip := net.ParseIP(a.Address); ip != nil && (ip.To4() != nil) == ipv4 {
+			return ip
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ctriple/drbd/pkg/agent"
+	"github.com/ctriple/drbd/pkg/defs"
+	"github.com/ctriple/drbd/pkg/sync/res"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// operate asks the node agent for op on the resource of pvc, and waits for the
+// result.
+func operate(client kubernetes.Interface, namespace, pvcName string, op agent.Operation, timeout time.Duration) error {
+	pv, err := volume(client, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+
+	nodes := pv.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Values
+	if diskless := pv.Annotations[defs.AnnDiskless]; diskless != "" {
+		nodes = append(nodes, strings.Split(diskless, ",")...)
+	}
+	if !res.Contains(nodes, op.Node) {
+		return fmt.Errorf("%s is not a node of %s: %v", op.Node, pv.Name, nodes)
+	}
+	if op.Peer != "" && (op.Peer == op.Node || !res.Contains(nodes, op.Peer)) {
+		return fmt.Errorf("%s is not a peer of %s on %s", op.Peer, pv.Name, op.Node)
+	}
+	if pending := pv.Annotations[defs.AnnOperation]; pending != "" {
+		return fmt.Errorf("%s has a pending operation: %s", pv.Name, pending)
+	}
+
+	data, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	pv.Annotations[defs.AnnOperation] = string(data)
+	if _, err := client.CoreV1().PersistentVolumes().Update(pv); err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s requested\n", pv.Name, op)
+
+	return awaitResult(client, pv.Name, op, timeout)
+}
+
+// awaitResult waits for the node agent to replace the operation requested on
+// pv with its result.
+func awaitResult(client kubernetes.Interface, pvName string, op agent.Operation, timeout time.Duration) error {
+	result := agent.OperationResult{}
+	err := wait.PollImmediate(2*time.Second, timeout, func() (bool, error) {
+		pv, err := client.CoreV1().PersistentVolumes().Get(pvName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if _, pending := pv.Annotations[defs.AnnOperation]; pending {
+			return false, nil
+		}
+		return true, json.Unmarshal([]byte(pv.Annotations[defs.AnnOperationResult]), &result)
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("%s: %s still pending after %v, is the agent on %s running?", pvName, op, timeout, op.Node)
+	}
+	if err != nil {
+		return err
+	}
+
+	if result.Error != "" {
+		return fmt.Errorf("%s: %s: %s, see agent log on %s", pvName, op, result.Error, op.Node)
+	}
+	fmt.Printf("%s: %s done\n", pvName, op)
+
+	return nil
+}
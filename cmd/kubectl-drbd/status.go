@@ -0,0 +1,127 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ctriple/drbd/pkg/crd"
+	"github.com/ctriple/drbd/pkg/defs"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// volume returns the pv bound to pvc, the resource name is the pv name
+func volume(client kubernetes.Interface, namespace, pvcName string) (*v1.PersistentVolume, error) {
+	pvc, err := client.CoreV1().PersistentVolumeClaims(namespace).Get(pvcName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if pvc.Spec.VolumeName == "" {
+		return nil, fmt.Errorf("pvc %s/%s is not bound", namespace, pvcName)
+	}
+
+	pv, err := client.CoreV1().PersistentVolumes().Get(pvc.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if provisioner := pv.Annotations[defs.AnnCreatedBy]; provisioner != defs.DrbdDriver {
+		return nil, fmt.Errorf("pv %s not provisioned by: %v", pv.Name, defs.DrbdDriver)
+	}
+
+	return pv, nil
+}
+
+// status shows the resource of pvc as seen by each of its nodes
+func status(client kubernetes.Interface, namespace, pvcName string) error {
+	pv, err := volume(client, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+	r, err := crd.Get(client.CoreV1().RESTClient(), pv.Name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Resource: %s\nClaim:    %s/%s\nSize:     %s\nPort:     %d\nDevice:   /dev/drbd%d\n",
+		r.Name, namespace, pvcName, r.Spec.Size, r.Spec.Port, r.Spec.Minor)
+	if result := pv.Annotations[defs.AnnOperationResult]; result != "" {
+		fmt.Printf("Last operation: %s\n", result)
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tID\tADDRESS\tROLE\tDISK\tCONNECTIONS\tOUT-OF-SYNC\tLAST-SYNC\tUPDATED")
+	for _, n := range r.Spec.Nodes {
+		s, ok := r.Status.Nodes[n.Name]
+		if !ok {
+			fmt.Fprintf(w, "%s\t%d\t%s\t-\t-\t-\t-\t-\t-\n", n.Name, n.NodeID, n.Address)
+			continue
+		}
+
+		var conns []string
+		for peer, state := range s.Connections {
+			conns = append(conns, peer+":"+state)
+		}
+		sort.Strings(conns)
+
+		lastSync := "-"
+		if s.LastSyncTime != nil {
+			lastSync = since(s.LastSyncTime.Time)
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%dKiB\t%s\t%s\n", n.Name, n.NodeID, n.Address,
+			s.Role, s.DiskState, strings.Join(conns, ","), s.OutOfSyncKiB, lastSync, since(s.UpdateTime.Time))
+	}
+
+	return w.Flush()
+}
+
+// statusAll shows one line for each resource, aggregated over its nodes
+func statusAll(client kubernetes.Interface) error {
+	resources, err := crd.List(client.CoreV1().RESTClient())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "RESOURCE\tCLAIM\tSIZE\tPRIMARY\tDISKS\tIN-SYNC")
+	for _, r := range resources {
+		claim := "-"
+		if pv, err := client.CoreV1().PersistentVolumes().Get(r.Name, metav1.GetOptions{}); err == nil && pv.Spec.ClaimRef != nil {
+			claim = pv.Spec.ClaimRef.Namespace + "/" + pv.Spec.ClaimRef.Name
+		}
+
+		primary := "-"
+		inSync := len(r.Status.Nodes) == len(r.Spec.Nodes)
+		var disks []string
+		for _, n := range r.Spec.Nodes {
+			s, ok := r.Status.Nodes[n.Name]
+			if !ok {
+				disks = append(disks, "Unknown")
+				continue
+			}
+			if s.Role == "Primary" {
+				primary = n.Name
+			}
+			disks = append(disks, s.DiskState)
+			inSync = inSync && s.InSync
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%v\n", r.Name, claim, r.Spec.Size, primary, strings.Join(disks, ","), inSync)
+	}
+
+	return w.Flush()
+}
+
+func since(t time.Time) string {
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
@@ -4,17 +4,44 @@
 package main
 
 import (
+	"flag"
+	"net/http"
+	"os"
+	"strings"
+
 	"github.com/ctriple/drbd/pkg/defs"
 	"github.com/ctriple/drbd/pkg/stor"
 	"github.com/golang/glog"
 	"github.com/kubernetes-sigs/sig-storage-lib-external-provisioner/controller"
+	"github.com/kubernetes-sigs/sig-storage-lib-external-provisioner/controller/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
+var (
+	httpAddr       = flag.String("http-address", ":9943", "Address serving /metrics, /healthz and /readyz")
+	syncJobTimeout = flag.Duration("sync-job-timeout", stor.SyncJobTimeout, "How long a sync job may take, such as copying a cloned volume, before provision or delete fails")
+
+	// DRBD replication off the pod network, see HACKING.md
+	replicationCIDR      = flag.String("replication-cidr", "", "Comma separated networks the DRBD addresses of nodes are chosen from, one path each")
+	replicationInterface = flag.String("replication-interface", "", "Comma separated interfaces the DRBD addresses of nodes are chosen from, one path each")
+	replicationFamily    = flag.String("replication-family", "ipv4", "Address family of DRBD replication on dual-stack nodes, ipv4 or ipv6")
+
+	// Only the leader provisions, standby replicas take over when it is gone
+	leaderElect          = flag.Bool("leader-elect", controller.DefaultLeaderElection, "Enable leader election, required to run more than one replica")
+	leaderElectNamespace = flag.String("leader-elect-namespace", os.Getenv("MY_POD_NAMESPACE"), "Namespace of the leader election lock")
+	leaseDuration        = flag.Duration("leader-elect-lease-duration", controller.DefaultLeaseDuration, "How long standby replicas wait before taking over an unrenewed lease")
+	renewDeadline        = flag.Duration("leader-elect-renew-deadline", controller.DefaultRenewDeadline, "How long the leader retries renewing its lease before giving up")
+	retryPeriod          = flag.Duration("leader-elect-retry-period", controller.DefaultRetryPeriod, "How long replicas wait between tries of acquiring or renewing the lease")
+)
+
 func main() {
+	flag.Parse()
+
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		glog.Fatalf("Failed to create config: %v", err)
@@ -30,9 +57,77 @@ func main() {
 		glog.Fatalf("Error getting server version: %v", err)
 	}
 
+	stor.SyncJobTimeout = *syncJobTimeout
+
 	flexProvisioner := stor.NewFlexProvisioner(clientset)
+	if err := flexProvisioner.SetReplicationNetwork(split(*replicationCIDR), split(*replicationInterface), *replicationFamily); err != nil {
+		glog.Fatalf("Invalid replication network: %v", err)
+	}
+
+	pc := controller.NewProvisionController(clientset, defs.DrbdDriver, flexProvisioner, serverVersion.GitVersion,
+		controller.LeaderElection(*leaderElect),
+		controller.LeaderElectionNamespace(*leaderElectNamespace),
+		controller.LeaseDuration(*leaseDuration),
+		controller.RenewDeadline(*renewDeadline),
+		controller.RetryPeriod(*retryPeriod),
+	)
 
-	pc := controller.NewProvisionController(clientset, defs.DrbdDriver, flexProvisioner, serverVersion.GitVersion)
+	go serveHTTP(clientset, pc)
 
 	pc.Run(wait.NeverStop)
 }
+
+// serveHTTP serves metrics and health of this provisioner. Standby replicas
+// serve too, they are ready as long as the api server is reachable.
+func serveHTTP(clientset kubernetes.Interface, pc *controller.ProvisionController) {
+	// NOTE: controller registers its own metrics only if it serves them
+	// itself, which it does after becoming leader.
+	prometheus.MustRegister(
+		metrics.PersistentVolumeClaimProvisionTotal,
+		metrics.PersistentVolumeClaimProvisionFailedTotal,
+		metrics.PersistentVolumeClaimProvisionDurationSeconds,
+		metrics.PersistentVolumeDeleteTotal,
+		metrics.PersistentVolumeDeleteFailedTotal,
+		metrics.PersistentVolumeDeleteDurationSeconds,
+	)
+	prometheus.MustRegister(stor.Metrics()...)
+
+	// Controller runs only when it is the leader
+	prometheus.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: "drbd",
+			Subsystem: "stor",
+			Name:      "leader",
+			Help:      "1 if this provisioner is the leader.",
+		},
+		func() float64 {
+			if pc.HasRun() {
+				return 1
+			}
+			return 0
+		},
+	))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := clientset.Discovery().ServerVersion(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	glog.Fatalln(http.ListenAndServe(*httpAddr, mux))
+}
+
+// split returns the comma separated values of a flag
+func split(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
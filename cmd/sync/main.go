@@ -4,10 +4,13 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path"
 	"strings"
+	"time"
 
+	"github.com/ctriple/drbd/pkg/crypt"
 	"github.com/ctriple/drbd/pkg/defs"
 	"github.com/ctriple/drbd/pkg/drbdadm"
 	"github.com/ctriple/drbd/pkg/sync"
@@ -28,57 +31,318 @@ func main() {
 		resName = os.Getenv(defs.SyncJob_EnvResName)
 		resSize = os.Getenv(defs.SyncJob_EnvResSize)
 		host    = os.Getenv(defs.SyncJob_EnvResHost)
+		node    = os.Getenv(defs.SyncJob_EnvNode)
 		ip      = os.Getenv(defs.SyncJob_EnvResIP)
+		net     = os.Getenv(defs.SyncJob_EnvResNet)
+		disk    = os.Getenv(defs.SyncJob_EnvResDisk)
+		options = os.Getenv(defs.SyncJob_EnvResOpts)
 
-		hosts = strings.Split(host, ",")
-		ips   = strings.Split(ip, ",")
+		source   = os.Getenv(defs.SyncJob_EnvResSource)
+		clone    = os.Getenv(defs.SyncJob_EnvResClone)
+		quiesced = os.Getenv(defs.SyncJob_EnvResQuiesced) == "true"
+
+		hosts    = strings.Split(host, ",")
+		ips      = strings.Split(ip, ",")
+		diskless = strings.Split(os.Getenv(defs.SyncJob_EnvResDiskless), ",")
+
+		archive    = os.Getenv(defs.SyncJob_EnvArchive)
+		archiveDir = os.Getenv(defs.SyncJob_EnvArchiveDir)
+		retention  = os.Getenv(defs.SyncJob_EnvArchiveRetention)
+
+		encryption = os.Getenv(defs.SyncJob_EnvEncryption)
 	)
 
 	switch defs.SyncJob(job) {
 	case defs.SyncJob_New:
-		if drbdadm.ShResource(resName) {
-			glog.Fatalln(resName, "already exist!")
+		opts, err := res.ParseEnv(net, disk, options)
+		if err != nil {
+			glog.Fatalln(err)
+		}
+		if opts.SharedSecret = os.Getenv(defs.SyncJob_EnvResSecret); opts.SharedSecret == "" {
+			glog.Fatalln("env:", defs.SyncJob_EnvResSecret, "not set!")
+		}
+		if opts.TLS() {
+			if err := checkTLS(); err != nil {
+				glog.Fatalln(err)
+			}
+		}
+		var key []byte
+		if encryption == defs.Encryption_Below {
+			if key = []byte(os.Getenv(defs.SyncJob_EnvEncryptionKey)); len(key) == 0 {
+				glog.Fatalln("env:", defs.SyncJob_EnvEncryptionKey, "not set!")
+			}
+		}
+		if node == "" {
+			glog.Fatalln("env:", defs.SyncJob_EnvNode, "not set!")
 		}
-		if err := doNew(resName, resSize, hosts, ips); err != nil {
+		if err := doNew(node, resName, resSize, hosts, ips, diskless, opts, key); err != nil {
+			glog.Fatalln(err)
+		}
+		if source == "" {
+			break
+		}
+		if err := doSeed(resName, source, clone, quiesced); err != nil {
+			doDel(resName, "", "", 0)
 			glog.Fatalln(err)
 		}
 
 	case defs.SyncJob_Del:
+		var archiveRetention time.Duration
+		if archive != "" {
+			d, err := time.ParseDuration(retention)
+			if err != nil {
+				glog.Fatalln(err)
+			}
+			archiveRetention = d
+		}
+		if err := doDel(resName, archive, archiveDir, archiveRetention); err != nil {
+			glog.Fatalln(err)
+		}
+
+	case defs.SyncJob_Check:
+		if err := doCheck(resName); err != nil {
+			glog.Fatalln(err)
+		}
+
+	case defs.SyncJob_ClearBitmap:
 		if !drbdadm.ShResource(resName) {
 			glog.Fatalln(resName, "does not exist!")
 		}
-		if err := doDel(resName); err != nil {
+		if err := doClearBitmap(resName); err != nil {
 			glog.Fatalln(err)
 		}
 
 	default:
-		glog.Fatalln("env:", defs.SyncJob_EnvJob, "must be", defs.SyncJob_New, "or", defs.SyncJob_Del)
+		glog.Fatalln("env:", defs.SyncJob_EnvJob, "must be", defs.SyncJob_New, "or", defs.SyncJob_Del, "or", defs.SyncJob_Check, "or", defs.SyncJob_ClearBitmap)
 	}
 }
 
-func doNew(resName, resSize string, hosts, ips []string) error {
-	if err := lvm.Create(defs.DrbdDiskVG, resName, resSize); err != nil {
-		return err
-	}
+// doNew creates the resource on node, this host. Steps already done by an earlier
+// interrupted run are skipped, what is left behind by a failed run is cleaned
+// up by doDel. With key the backing disk is encrypted, see
+// defs.Encryption_Below.
+func doNew(node, resName, resSize string, hosts, ips, diskless []string, opts res.Options, key []byte) error {
 	// lvm allocated disk pattern: /dev/{vg}/{name}
 	disk := path.Join("/dev", defs.DrbdDiskVG, resName)
-	if err := res.New(resName, disk, hosts, ips); err != nil {
-		lvm.Remove(disk)
+	hasDisk := !res.Contains(diskless, node)
+
+	if hasDisk && !lvm.Exists(disk) {
+		if err := lvm.Create(defs.DrbdDiskVG, resName, resSize); err != nil {
+			return err
+		}
+	}
+
+	// Encrypted below drbd, the mapping is the disk of the resource on
+	// every host
+	if key != nil {
+		name := crypt.BelowName(resName)
+		if hasDisk {
+			luks, err := crypt.IsLuks(disk)
+			if err != nil {
+				return err
+			}
+			if !luks {
+				if err := crypt.Format(disk, key); err != nil {
+					return err
+				}
+			}
+		}
+		if hasDisk && !crypt.Opened(name) {
+			if err := crypt.Open(disk, name, key); err != nil {
+				return err
+			}
+		}
+		disk = crypt.Mapper(name)
+	}
+
+	// Same resource file on every run
+	if err := res.New(resName, disk, hosts, ips, diskless, opts); err != nil {
+		return err
+	}
+
+	// Already up
+	if _, err := drbdadm.Status(resName); err == nil {
+		return nil
+	}
+
+	if hasDisk && !drbdadm.HasMD(resName) {
+		if err := drbdadm.CreateMD(resName); err != nil {
+			return err
+		}
+	}
+	if err := drbdadm.Up(resName); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkTLS makes sure the DRBD of this host has the tls transport, the tls
+// handshake itself is done by tlshd of ktls-utils on the host.
+func checkTLS() error {
+	code, err := drbdadm.KernelVersion()
+	if err != nil {
+		return err
+	}
+	if code < defs.DrbdTLSVersionCode {
+		return fmt.Errorf("tls: DRBD %#x on this host has no tls transport, 9.2 or later required", code)
+	}
+
+	return nil
+}
+
+// doSeed fills the newly created resource with the data of resource source,
+// which must be up on this node, and marks this node UpToDate so that DRBD
+// initial sync copies the data to all other replicas. A resource UpToDate was
+// seeded by an earlier run of this job, which is not repeated.
+func doSeed(resName, source, clone string, quiesced bool) error {
+	if status, err := drbdadm.Status(resName); err == nil && isUpToDate(status) {
+		return nil
+	}
+
+	status, err := drbdadm.Status(source)
+	if err != nil {
+		return err
+	}
+	for _, node := range status.Primaries() {
+		// A snapshot is taken atomically on this node, only writers
+		// elsewhere make it inconsistent. Of a source in use here it is
+		// still only crash consistent.
+		if node == "" && clone == defs.Clone_Snapshot {
+			continue
+		}
+		if !quiesced {
+			return fmt.Errorf("clone source: %s is Primary on %q and not quiesced", source, node)
+		}
+	}
+
+	srcDisk, err := drbdadm.ShLlDev(source)
+	if err != nil {
 		return err
 	}
+	dstDisk, err := drbdadm.ShLlDev(resName)
+	if err != nil {
+		return err
+	}
+
+	// The new resource is not serving data yet, write to its backing disk
+	// directly. Source drbd metadata is copied along and then overwritten or
+	// left beyond the end of the copied filesystem.
+	if err := drbdadm.Down(resName); err != nil {
+		return err
+	}
+
+	switch clone {
+	case defs.Clone_Snapshot:
+		snapName := source + "-clone-" + resName
+		if err := lvm.Snapshot(srcDisk, snapName); err != nil {
+			return err
+		}
+		snapDisk := path.Join(path.Dir(srcDisk), snapName)
+		err := lvm.Copy(snapDisk, dstDisk)
+		if rerr := lvm.Remove(snapDisk); err == nil {
+			err = rerr
+		}
+		if err != nil {
+			return err
+		}
+	case defs.Clone_Copy:
+		if err := lvm.Copy(srcDisk, dstDisk); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("clone method: %q must be %s or %s", clone, defs.Clone_Snapshot, defs.Clone_Copy)
+	}
+
 	if err := drbdadm.CreateMD(resName); err != nil {
-		lvm.Remove(disk)
-		res.Del(resName)
 		return err
 	}
 	if err := drbdadm.Up(resName); err != nil {
 		return err
 	}
 
+	// Forcing primary makes our data UpToDate, peers coming up later sync
+	// from us.
+	if err := drbdadm.ForcePrimary(resName); err != nil {
+		return err
+	}
+	if err := drbdadm.Secondary(resName); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func isUpToDate(status drbdadm.ResStatus) bool {
+	for _, d := range status.Devices {
+		if d.DiskState != drbdadm.DiskUpToDate {
+			return false
+		}
+	}
+	return len(status.Devices) > 0
+}
+
+// doClearBitmap waits for all peers of the newly created resource to connect,
+// and then marks every replica UpToDate without a full sync.
+func doClearBitmap(resName string) error {
+	deadline := time.Now().Add(defs.DrbdConnectTimeout)
+
+	for {
+		status, err := drbdadm.Status(resName)
+		if err != nil {
+			return err
+		}
+		if status.Connected() {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s: peers not connected in %v", resName, defs.DrbdConnectTimeout)
+		}
+		time.Sleep(time.Second)
+	}
+
+	return drbdadm.NewCurrentUUID(resName)
+}
+
+// doCheck fails if resource is in use on this host
+func doCheck(resName string) error {
+	// Not configured or down
+	if !drbdadm.ShResource(resName) {
+		return nil
+	}
+	if _, err := drbdadm.Status(resName); err != nil {
+		return nil
+	}
+
+	inUse, err := drbdadm.InUse(resName)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return fmt.Errorf("%s is in use", resName)
+	}
+
 	return nil
 }
 
-func doDel(resName string) error {
+// doDel deletes resource on this host, its backing disk is archived first if
+// archive is set, see defs.Archive_Zstd and defs.Archive_Rename. The archive
+// keeps retention, archives of the host older than their own retention are
+// pruned afterwards.
+func doDel(resName, archive, archiveDir string, retention time.Duration) error {
+	// Resource file never written or already removed, only the disk may be
+	// left behind.
+	if !drbdadm.ShResource(resName) {
+		if err := crypt.Close(crypt.BelowName(resName)); err != nil {
+			return err
+		}
+		return lvm.Remove(path.Join("/dev", defs.DrbdDiskVG, resName))
+	}
+
+	if err := doCheck(resName); err != nil {
+		return err
+	}
+
 	disk, err := drbdadm.ShLlDev(resName)
 	if err != nil {
 		return err
@@ -86,6 +350,33 @@ func doDel(resName string) error {
 	if err := drbdadm.Down(resName); err != nil {
 		return err
 	}
+
+	// Encrypted below drbd, archive and remove the encrypted disk
+	if disk == crypt.Mapper(crypt.BelowName(resName)) {
+		if err := crypt.Close(crypt.BelowName(resName)); err != nil {
+			drbdadm.Up(resName)
+			return err
+		}
+		disk = path.Join("/dev", defs.DrbdDiskVG, resName)
+	}
+
+	switch archive {
+	case defs.Archive_Zstd:
+		file, err := lvm.Export(disk, archiveDir, retention)
+		if err != nil {
+			drbdadm.Up(resName)
+			return err
+		}
+		glog.Infoln(resName, "archived to", file)
+	case defs.Archive_Rename:
+		if err := lvm.Archive(defs.DrbdDiskVG, path.Base(disk), retention); err != nil {
+			drbdadm.Up(resName)
+			return err
+		}
+		glog.Infoln(resName, "archived in", defs.DrbdDiskVG)
+	}
+
+	// Nothing left to remove if renamed
 	if err := lvm.Remove(disk); err != nil {
 		drbdadm.Up(resName)
 		return err
@@ -94,5 +385,11 @@ func doDel(resName string) error {
 		return err
 	}
 
+	if archive != "" {
+		if err := lvm.Prune(defs.DrbdDiskVG, archiveDir); err != nil {
+			glog.Warningln(resName, "prune archives:", err)
+		}
+	}
+
 	return nil
 }
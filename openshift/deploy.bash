@@ -6,8 +6,8 @@
 
 # This scripts will deploy drbd powered kubernetes dynamic storage solution into
 # your cluster. It will first create namespaces and serviceaccount as needed,
-# and grant priorities to the serviceaccount. then create external provisioner
-# and storageclass.
+# and grant priorities to the serviceaccount. then create external provisioner,
+# storageclass, node agent and the DrbdResource custom resource.
 
 set -o errexit
 set -o nounset
@@ -21,3 +21,5 @@ oc adm policy add-cluster-role-to-user cluster-admin -z drbd -n ctriple-drbd
 
 oc create -f 3-dc.yaml
 oc create -f 4-sc.yaml
+oc create -f 5-ds.yaml
+oc create -f 6-crd.yaml
@@ -0,0 +1,73 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package agent
+
+import (
+	"net"
+	"strings"
+
+	"github.com/ctriple/drbd/pkg/defs"
+	"github.com/golang/glog"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// publishAddresses records the interface addresses of this node on its Node,
+// the provisioner chooses replication addresses by interface or network from
+// them. The Node is only updated when the addresses changed.
+func (a *Agent) publishAddresses() {
+	addrs, err := interfaceAddresses()
+	if err != nil {
+		glog.Errorln("addresses:", err)
+		return
+	}
+	value := strings.Join(addrs, ",")
+
+	nodeClient := a.client.CoreV1().Nodes()
+	node, err := nodeClient.Get(a.node, metav1.GetOptions{})
+	if err != nil {
+		glog.Errorln("addresses:", err)
+		return
+	}
+	if node.Annotations[defs.AnnAddresses] == value {
+		return
+	}
+
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	node.Annotations[defs.AnnAddresses] = value
+	if _, err := nodeClient.Update(node); err != nil {
+		glog.Errorln("addresses:", err)
+	}
+}
+
+// interfaceAddresses returns {interface}={ip} of the global unicast addresses
+// of all interfaces up, the agent runs in the host network.
+func interfaceAddresses() ([]string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ifaddrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range ifaddrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || !ipnet.IP.IsGlobalUnicast() {
+				continue
+			}
+			addrs = append(addrs, iface.Name+"="+ipnet.IP.String())
+		}
+	}
+
+	return addrs, nil
+}
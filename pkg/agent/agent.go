@@ -0,0 +1,162 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package agent
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ctriple/drbd/pkg/crd"
+	"github.com/ctriple/drbd/pkg/defs"
+	"github.com/ctriple/drbd/pkg/drbdadm"
+	"github.com/golang/glog"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	AgentComponent = "drbd-agent"
+)
+
+// Agent runs on every drbd node, it watches the drbd resources on this node
+// and acts on behalf of the kubernetes objects they belong to.
+type Agent struct {
+	client   kubernetes.Interface
+	node     string
+	recorder record.EventRecorder
+
+	mu sync.Mutex
+	// pvc namespace/name of the resources on this node
+	claims map[string]string
+	// what the orphans on this node left behind, as of the last gc
+	orphans map[string][]string
+	// how many of each kind of orphans were removed
+	removed map[string]int
+	// status of the resources on this node, as of the last report
+	reported map[string]crd.DrbdNodeStatus
+
+	// online verify, see EnableVerify
+	verifyInterval time.Duration
+	verifyRate     string
+	verifyResync   bool
+	// when verify of the resources still running started
+	verifying map[string]time.Time
+	// data out of sync with each peer found by the last verify
+	mismatch map[string]map[string]uint64
+	// how many verifies found data out of sync
+	mismatches map[string]int
+
+	// drift from spec, see EnableDrift
+	driftInterval   time.Duration
+	secretNamespace string
+	// when drift of the resources was last checked, and missing resources
+	// were last restored
+	drifted  map[string]time.Time
+	restored time.Time
+	// how many drifts of each kind were corrected
+	corrections map[string]int
+}
+
+func NewAgent(client kubernetes.Interface, node string) *Agent {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(glog.Infof)
+	broadcaster.StartRecordingToSink(&corev1client.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: AgentComponent, Host: node})
+
+	agent := &Agent{
+		client:   client,
+		node:     node,
+		recorder: recorder,
+		claims:   map[string]string{},
+		orphans:  map[string][]string{},
+		removed:  map[string]int{},
+		reported: map[string]crd.DrbdNodeStatus{},
+
+		verifying:  map[string]time.Time{},
+		mismatch:   map[string]map[string]uint64{},
+		mismatches: map[string]int{},
+
+		drifted:     map[string]time.Time{},
+		corrections: map[string]int{},
+	}
+
+	return agent
+}
+
+// Run checks all drbd resources on this node every interval, until stop is
+// closed.
+func (a *Agent) Run(interval time.Duration, stop <-chan struct{}) {
+	wait.Until(a.sync, interval, stop)
+}
+
+func (a *Agent) sync() {
+	a.publishAddresses()
+
+	resNames, err := drbdadm.ShResources()
+	if err != nil {
+		glog.Errorln("sh-resources:", err)
+		return
+	}
+	a.restore(resNames)
+
+	claims := map[string]string{}
+	defer func() {
+		a.mu.Lock()
+		a.claims = claims
+		a.mu.Unlock()
+	}()
+
+	for _, resName := range resNames {
+		// Resource name is the name of the pv it backs
+		pv, err := a.client.CoreV1().PersistentVolumes().Get(resName, metav1.GetOptions{})
+		if err != nil {
+			glog.Warningf("%s: %v", resName, err)
+			continue
+		}
+		if provisioner := pv.Annotations[defs.AnnCreatedBy]; provisioner != defs.DrbdDriver {
+			continue
+		}
+		if ref := pv.Spec.ClaimRef; ref != nil {
+			claims[resName] = ref.Namespace + "/" + ref.Name
+		}
+
+		a.unlock(pv)
+		a.operate(pv)
+		a.rejoin(pv)
+
+		status, err := drbdadm.Status(resName)
+		if err != nil {
+			glog.Warningf("%s: %v", resName, err)
+			continue
+		}
+
+		a.splitBrain(pv, status)
+		a.drift(pv, status)
+		a.report(resName, status)
+		a.verify(pv, status)
+	}
+}
+
+// claim returns namespace/name of the pvc bound to resource, as of the last
+// sync.
+func (a *Agent) claim(resName string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.claims[resName]
+}
+
+// event records an event on the pv, and on the pvc bound to it
+func (a *Agent) event(pv *v1.PersistentVolume, eventtype, reason, messageFmt string, args ...interface{}) {
+	a.recorder.Eventf(pv, eventtype, reason, messageFmt, args...)
+	if pv.Spec.ClaimRef != nil {
+		a.recorder.Eventf(pv.Spec.ClaimRef, eventtype, reason, messageFmt, args...)
+	}
+}
@@ -0,0 +1,75 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package agent
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/ctriple/drbd/pkg/crypt"
+	"github.com/ctriple/drbd/pkg/defs"
+	"github.com/ctriple/drbd/pkg/drbdadm"
+	"github.com/ctriple/drbd/pkg/sync/lvm"
+	"github.com/ctriple/drbd/pkg/sync/res"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// unlock opens the backing disk of resource encrypted below DRBD, which is
+// closed since this node rebooted, and attaches it to the resource.
+func (a *Agent) unlock(pv *v1.PersistentVolume) {
+	if pv.Annotations[defs.AnnEncryption] != defs.Encryption_Below {
+		return
+	}
+	if res.Contains(strings.Split(pv.Annotations[defs.AnnDiskless], ","), a.node) {
+		return
+	}
+
+	resName := pv.Name
+	name := crypt.BelowName(resName)
+	if crypt.Opened(name) {
+		return
+	}
+	// Lost backing disk, see rejoin
+	if _, rejoining := rejoins(pv)[a.node]; rejoining || !lvm.Exists(path.Join("/dev", defs.DrbdDiskVG, resName)) {
+		return
+	}
+
+	key, err := a.encryptionKey(pv)
+	if err != nil {
+		a.event(pv, v1.EventTypeWarning, "UnlockFailed", "%s: %s: %v", resName, a.node, err)
+		return
+	}
+	if err := crypt.Open(path.Join("/dev", defs.DrbdDiskVG, resName), name, key); err != nil {
+		a.event(pv, v1.EventTypeWarning, "UnlockFailed", "%s: %s open backing disk: %v", resName, a.node, err)
+		return
+	}
+	if err := drbdadm.Adjust(resName); err != nil {
+		a.event(pv, v1.EventTypeWarning, "UnlockFailed", "%s: %s attach backing disk: %v", resName, a.node, err)
+		return
+	}
+
+	a.event(pv, v1.EventTypeNormal, "Unlocked", "%s: %s opened and attached encrypted backing disk", resName, a.node)
+}
+
+// encryptionKey reads the key of pv from its encryption Secret
+func (a *Agent) encryptionKey(pv *v1.PersistentVolume) ([]byte, error) {
+	ref := strings.SplitN(pv.Annotations[defs.AnnEncryptionSecret], "/", 2)
+	if len(ref) != 2 {
+		return nil, fmt.Errorf("%s: %q must be namespace/name", defs.AnnEncryptionSecret, pv.Annotations[defs.AnnEncryptionSecret])
+	}
+
+	secret, err := a.client.CoreV1().Secrets(ref[0]).Get(ref[1], metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	key := secret.Data[defs.EncryptionSecretKey]
+	if len(key) == 0 {
+		return nil, fmt.Errorf("secret %s/%s has no %q", ref[0], ref[1], defs.EncryptionSecretKey)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,222 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package agent
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ctriple/drbd/pkg/crd"
+	"github.com/ctriple/drbd/pkg/crypt"
+	"github.com/ctriple/drbd/pkg/defs"
+	"github.com/ctriple/drbd/pkg/drbdadm"
+	"github.com/ctriple/drbd/pkg/sync/res"
+	"github.com/golang/glog"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// What drifted from the spec of a resource
+const (
+	driftConfig  = "config"  // resource file
+	driftRunning = "running" // running resource
+)
+
+// EnableDrift makes the agent check every resource every interval for drift
+// from the spec in its DrbdResource. namespace is where the peer Secrets are.
+func (a *Agent) EnableDrift(interval time.Duration, namespace string) {
+	a.driftInterval = interval
+	a.secretNamespace = namespace
+}
+
+// drift rewrites the resource file of resource on this node if it differs
+// from the spec, such as edited by hand or lost with a reimaged node, and
+// adjusts the running resource if it differs from the resource file, as
+// drbdsetup show has it. Every correction is reported.
+//
+// A resource deliberately disconnected, such as by kubectl drbd or split
+// brain, is left alone, adjust would connect it again. So is a resource being
+// verified at a lower resync rate, or rejoining with a new backing disk.
+func (a *Agent) drift(pv *v1.PersistentVolume, status drbdadm.ResStatus) {
+	if a.driftInterval <= 0 {
+		return
+	}
+	resName := pv.Name
+
+	a.mu.Lock()
+	last := a.drifted[resName]
+	_, verifying := a.verifying[resName]
+	a.mu.Unlock()
+
+	if verifying || time.Since(last) < a.driftInterval {
+		return
+	}
+	if _, rejoining := rejoins(pv)[a.node]; rejoining {
+		return
+	}
+	for _, c := range status.Connections {
+		if c.ConnectionState == drbdadm.ConnStandAlone {
+			return
+		}
+	}
+
+	a.mu.Lock()
+	a.drifted[resName] = time.Now()
+	a.mu.Unlock()
+
+	spec, err := a.spec(pv)
+	if err != nil {
+		glog.Warningf("%s: drift: %v", resName, err)
+		return
+	}
+	// Provisioned before DrbdResource was introduced
+	if spec == nil {
+		return
+	}
+	// Moved away from this node, the replica is removed by leave
+	if _, ok := spec.Node(a.node); !ok {
+		return
+	}
+
+	var diffs []string
+	if onDisk, err := res.Load(resName); err != nil {
+		diffs = []string{err.Error()}
+	} else {
+		diffs = res.Diff(onDisk, *spec)
+	}
+	if len(diffs) > 0 {
+		if err := spec.Write(); err != nil {
+			a.event(pv, v1.EventTypeWarning, "DriftFailed", "%s: rewrite resource file on %s: %v", resName, a.node, err)
+			return
+		}
+		a.corrected(driftConfig)
+		a.event(pv, v1.EventTypeWarning, "ConfigDrift", "%s: resource file on %s differed in %s, rewritten", resName, a.node, strings.Join(diffs, ", "))
+	}
+
+	cmds, err := drbdadm.AdjustDryRun(resName)
+	if err != nil {
+		a.event(pv, v1.EventTypeWarning, "DriftFailed", "%s: compare running resource on %s: %v", resName, a.node, err)
+		return
+	}
+	if len(cmds) == 0 {
+		return
+	}
+	if err := drbdadm.Adjust(resName); err != nil {
+		a.event(pv, v1.EventTypeWarning, "DriftFailed", "%s: adjust on %s: %v", resName, a.node, err)
+		return
+	}
+	a.corrected(driftRunning)
+	a.event(pv, v1.EventTypeWarning, "RunningDrift", "%s: running resource on %s differed, adjusted by: %s", resName, a.node, strings.Join(cmds, "; "))
+}
+
+// restore writes the resource files missing on this node, such as after it
+// was reimaged, for the bound pvs with a replica here. resNames are those with
+// a resource file. The resources are brought up by adjust.
+func (a *Agent) restore(resNames []string) {
+	if a.driftInterval <= 0 || time.Since(a.restored) < a.driftInterval {
+		return
+	}
+	a.restored = time.Now()
+
+	pvs, err := a.client.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
+	if err != nil {
+		glog.Warningln("restore:", err)
+		return
+	}
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if provisioner := pv.Annotations[defs.AnnCreatedBy]; provisioner != defs.DrbdDriver {
+			continue
+		}
+		// Released pvs are being deleted
+		if pv.Status.Phase != v1.VolumeBound || res.Contains(resNames, pv.Name) {
+			continue
+		}
+		diskless := strings.Split(pv.Annotations[defs.AnnDiskless], ",")
+		if !res.Contains(pvHosts(pv), a.node) && !res.Contains(diskless, a.node) {
+			continue
+		}
+
+		spec, err := a.spec(pv)
+		if err != nil {
+			glog.Warningf("%s: restore: %v", pv.Name, err)
+			continue
+		}
+		if spec == nil {
+			continue
+		}
+		// Being moved to this node, the spec does not have it yet
+		if _, ok := spec.Node(a.node); !ok {
+			continue
+		}
+		if err := spec.Write(); err != nil {
+			a.event(pv, v1.EventTypeWarning, "DriftFailed", "%s: restore resource file on %s: %v", pv.Name, a.node, err)
+			continue
+		}
+		a.corrected(driftConfig)
+		a.event(pv, v1.EventTypeWarning, "ConfigDrift", "%s: resource file on %s missing, restored", pv.Name, a.node)
+
+		if err := drbdadm.Adjust(pv.Name); err != nil {
+			a.event(pv, v1.EventTypeWarning, "DriftFailed", "%s: adjust on %s: %v", pv.Name, a.node, err)
+			continue
+		}
+		a.corrected(driftRunning)
+	}
+}
+
+func (a *Agent) corrected(kind string) {
+	a.mu.Lock()
+	a.corrections[kind]++
+	a.mu.Unlock()
+}
+
+// spec returns the resource file pv should have, nil if pv has no
+// DrbdResource.
+func (a *Agent) spec(pv *v1.PersistentVolume) (*res.Config, error) {
+	r, err := crd.Get(a.client.CoreV1().RESTClient(), pv.Name)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := append([]crd.DrbdNode{}, r.Spec.Nodes...)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].NodeID < nodes[j].NodeID })
+
+	var hosts, ips, diskless []string
+	for _, n := range nodes {
+		hosts = append(hosts, n.Name)
+		ips = append(ips, res.JoinPaths(strings.Split(n.Address, ",")))
+		if n.Diskless {
+			diskless = append(diskless, n.Name)
+		}
+	}
+
+	disk := path.Join("/dev", defs.DrbdDiskVG, pv.Name)
+	if pv.Annotations[defs.AnnEncryption] == defs.Encryption_Below {
+		disk = crypt.Mapper(crypt.BelowName(pv.Name))
+	}
+
+	opts := res.Options{
+		Net:      r.Spec.Options.Net,
+		Disk:     r.Spec.Options.Disk,
+		Resource: r.Spec.Options.Resource,
+	}
+	// Volumes provisioned before peer authentication have no secret
+	secret, err := a.client.CoreV1().Secrets(a.secretNamespace).Get(defs.PeerSecretPrefix+pv.Name, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		opts.SharedSecret = string(secret.Data[defs.PeerSecretKey])
+	case !apierrors.IsNotFound(err):
+		return nil, err
+	}
+
+	c := res.NewConfig(pv.Name, disk, hosts, ips, diskless, opts)
+	return &c, nil
+}
@@ -0,0 +1,226 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package agent
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ctriple/drbd/pkg/defs"
+	"github.com/ctriple/drbd/pkg/drbdadm"
+	"github.com/ctriple/drbd/pkg/sync/res"
+	"github.com/golang/glog"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ServeFence serves fence requests of the DRBD fence-peer and unfence-peer
+// handlers on this node, see flex driver fence-peer action. It listens on a
+// unix socket in a dir only root may access, so that no other process on the
+// node can fence nodes.
+func (a *Agent) ServeFence(socket string) error {
+	dir := path.Dir(socket)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return err
+	}
+	// Left behind by the last agent
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		l.Close()
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fence", func(w http.ResponseWriter, r *http.Request) {
+		resName, peer, ok := fenceArgs(w, r)
+		if !ok {
+			return
+		}
+		result, err := a.fence(resName, peer)
+		if err != nil {
+			glog.Warningf("%s %s %s: %v", r.URL.Path, resName, peer, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(result))
+	})
+	mux.HandleFunc("/unfence", func(w http.ResponseWriter, r *http.Request) {
+		resName, peer, ok := fenceArgs(w, r)
+		if !ok {
+			return
+		}
+		if err := a.unfence(resName, peer); err != nil {
+			glog.Warningf("%s %s %s: %v", r.URL.Path, resName, peer, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+
+	return http.Serve(l, mux)
+}
+
+func fenceArgs(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	resName, peer := r.FormValue("resource"), r.FormValue("peer")
+	if resName == "" || peer == "" {
+		http.Error(w, "resource and peer required", http.StatusBadRequest)
+		return "", "", false
+	}
+	return resName, peer, true
+}
+
+// fence makes sure the peer node can no longer write to resource before this
+// node becomes Primary, and returns how, see defs.Fence_Fenced.
+//
+// If this node has quorum, the peer cut off from it has none and can not
+// write, DRBD outdates it. Otherwise the peer must be confirmed down: its Node
+// is gone, or tainted out-of-service by the admin after powering it off. Such
+// a peer is cordoned and tainted so that nothing runs there when it is back,
+// until it is in sync again. A NotReady peer without that taint may be cut
+// off and still Primary, and a Ready peer may run the pod using this
+// resource, fencing both is refused and so is the promotion.
+func (a *Agent) fence(resName, peer string) (string, error) {
+	if quorate(resName) {
+		glog.Infof("%s: %s has quorum, peer %s outdated for %s", a.node, a.node, peer, resName)
+		return defs.Fence_Outdated, nil
+	}
+
+	nodeClient := a.client.CoreV1().Nodes()
+
+	node, err := nodeClient.Get(peer, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		glog.Infof("%s: peer %s removed from cluster, fenced for %s", a.node, peer, resName)
+		return defs.Fence_Fenced, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, c := range node.Status.Conditions {
+		if c.Type == v1.NodeReady && c.Status == v1.ConditionTrue {
+			glog.Warningf("%s: peer %s is Ready, demote %s there first", a.node, peer, resName)
+			return defs.Fence_Refused, nil
+		}
+	}
+	if !hasTaint(node, defs.TaintOutOfService) {
+		if pv, err := a.client.CoreV1().PersistentVolumes().Get(resName, metav1.GetOptions{}); err == nil {
+			a.event(pv, v1.EventTypeWarning, "FenceUnconfirmed", "%s: %s can not confirm NotReady peer %s is down, taint it %s after powering it off",
+				resName, a.node, peer, defs.TaintOutOfService)
+		}
+		return defs.Fence_Unconfirmed, nil
+	}
+
+	resources := fencedResources(node)
+	if !res.Contains(resources, resName) {
+		resources = append(resources, resName)
+	}
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	node.Annotations[defs.AnnFenced] = strings.Join(resources, ",")
+	node.Spec.Unschedulable = true
+	if !hasTaint(node, defs.TaintFenced) {
+		node.Spec.Taints = append(node.Spec.Taints, v1.Taint{
+			Key:    defs.TaintFenced,
+			Value:  "true",
+			Effect: v1.TaintEffectNoExecute,
+		})
+	}
+	if _, err := nodeClient.Update(node); err != nil {
+		return "", err
+	}
+
+	glog.Infof("%s: fenced peer %s for %s", a.node, peer, resName)
+	if pv, err := a.client.CoreV1().PersistentVolumes().Get(resName, metav1.GetOptions{}); err == nil {
+		a.event(pv, v1.EventTypeWarning, "Fenced", "%s: %s fenced peer %s which is out of service", resName, a.node, peer)
+	}
+
+	return defs.Fence_Fenced, nil
+}
+
+// quorate returns true if resource has quorum configured and this node has it
+func quorate(resName string) bool {
+	c, err := res.Load(resName)
+	if err != nil {
+		glog.Warningf("%s: %v", resName, err)
+		return false
+	}
+	if q := c.Options["quorum"]; q == "" || q == "off" {
+		return false
+	}
+
+	status, err := drbdadm.Status(resName)
+	if err != nil {
+		return false
+	}
+	return status.Quorate()
+}
+
+// unfence lifts the fence of resource on the peer node, the node is
+// uncordoned and untainted once no resources fence it any longer.
+func (a *Agent) unfence(resName, peer string) error {
+	nodeClient := a.client.CoreV1().Nodes()
+
+	node, err := nodeClient.Get(peer, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	resources := fencedResources(node)
+	if !res.Contains(resources, resName) {
+		return nil
+	}
+
+	var left []string
+	for _, r := range resources {
+		if r != resName {
+			left = append(left, r)
+		}
+	}
+	if len(left) > 0 {
+		node.Annotations[defs.AnnFenced] = strings.Join(left, ",")
+	} else {
+		delete(node.Annotations, defs.AnnFenced)
+		node.Spec.Unschedulable = false
+
+		var taints []v1.Taint
+		for _, t := range node.Spec.Taints {
+			if t.Key != defs.TaintFenced {
+				taints = append(taints, t)
+			}
+		}
+		node.Spec.Taints = taints
+	}
+
+	_, err = nodeClient.Update(node)
+	return err
+}
+
+func fencedResources(node *v1.Node) []string {
+	if fenced := node.Annotations[defs.AnnFenced]; fenced != "" {
+		return strings.Split(fenced, ",")
+	}
+	return nil
+}
+
+func hasTaint(node *v1.Node, key string) bool {
+	for _, t := range node.Spec.Taints {
+		if t.Key == key {
+			return true
+		}
+	}
+	return false
+}
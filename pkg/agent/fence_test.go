@@ -0,0 +1,68 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package agent
+
+import (
+	"testing"
+
+	"github.com/ctriple/drbd/pkg/defs"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func notReady(name string, taints ...v1.Taint) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       v1.NodeSpec{Taints: taints},
+		Status: v1.NodeStatus{Conditions: []v1.NodeCondition{
+			{Type: v1.NodeReady, Status: v1.ConditionUnknown},
+		}},
+	}
+}
+
+func TestFence(t *testing.T) {
+	ready := notReady("node1")
+	ready.Status.Conditions[0].Status = v1.ConditionTrue
+	client := fake.NewSimpleClientset(
+		ready,
+		notReady("node2"),
+		notReady("node3", v1.Taint{Key: defs.TaintOutOfService, Effect: v1.TaintEffectNoExecute}),
+	)
+	a := NewAgent(client, "node0")
+
+	for _, c := range []struct {
+		peer   string
+		result string
+	}{
+		{"node1", defs.Fence_Refused},
+		{"node2", defs.Fence_Unconfirmed},
+		{"node3", defs.Fence_Fenced},
+		{"node4", defs.Fence_Fenced},
+	} {
+		result, err := a.fence("ns-pvc", c.peer)
+		if err != nil {
+			t.Fatalf("%s: %v", c.peer, err)
+		}
+		if result != c.result {
+			t.Errorf("%s: expect %s, got %s", c.peer, c.result, result)
+		}
+	}
+
+	node, err := client.CoreV1().Nodes().Get("node3", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !node.Spec.Unschedulable || !hasTaint(node, defs.TaintFenced) || node.Annotations[defs.AnnFenced] != "ns-pvc" {
+		t.Fatalf("expect node3 cordoned and tainted: %+v", node)
+	}
+	node, err = client.CoreV1().Nodes().Get("node2", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Spec.Unschedulable {
+		t.Fatal("expect unconfirmed node2 left alone")
+	}
+}
@@ -0,0 +1,218 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package agent
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ctriple/drbd/pkg/crd"
+	"github.com/ctriple/drbd/pkg/crypt"
+	"github.com/ctriple/drbd/pkg/defs"
+	"github.com/ctriple/drbd/pkg/drbdadm"
+	"github.com/ctriple/drbd/pkg/sync/lvm"
+	"github.com/ctriple/drbd/pkg/sync/res"
+	"github.com/golang/glog"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// What an orphan leaves behind on a node, in the order they are removed
+const (
+	orphanResource = "resource" // resource is up
+	orphanConfig   = "config"   // generated resource file
+	orphanDisk     = "disk"     // backing disk
+)
+
+// RunGC looks for orphans on this node every interval, until stop is closed.
+// Orphans are only reported unless remove is set, dryRun only logs what would
+// be removed.
+func (a *Agent) RunGC(interval time.Duration, remove, dryRun bool, stop <-chan struct{}) {
+	wait.Until(func() { a.gc(remove, dryRun) }, interval, stop)
+}
+
+// gc compares the generated resource files, the tagged disks and the running
+// resources on this node with the pvs in the cluster. Whatever no pv or
+// provisioning pvc wants on this node is an orphan left behind by a failed
+// provision or delete.
+//
+// An orphan is reported when first found, and only removed if it is still
+// there in the next round, so that it can not race with a provision which
+// just started.
+func (a *Agent) gc(remove, dryRun bool) {
+	wanted, err := a.wanted()
+	if err != nil {
+		glog.Errorln("gc:", err)
+		return
+	}
+
+	configs, err := res.Generated()
+	if err != nil {
+		glog.Errorln("gc:", err)
+		return
+	}
+	lvs, err := lvm.List(defs.DrbdDiskVG)
+	if err != nil {
+		glog.Errorln("gc:", err)
+		return
+	}
+
+	found := map[string][]string{}
+	for _, resName := range configs {
+		if _, err := drbdadm.Status(resName); err == nil {
+			found[resName] = append(found[resName], orphanResource)
+		}
+		found[resName] = append(found[resName], orphanConfig)
+	}
+	for _, lv := range lvs {
+		// Untagged disks are only ours if we generated their resource
+		// file, such as disks created before tagging.
+		if lv.Tagged(defs.DrbdDiskTag) || res.Contains(configs, lv.Name) {
+			found[lv.Name] = append(found[lv.Name], orphanDisk)
+		}
+	}
+
+	orphans := map[string][]string{}
+	for resName, kinds := range found {
+		if !wanted[resName] {
+			orphans[resName] = kinds
+		}
+	}
+
+	a.mu.Lock()
+	last := a.orphans
+	a.orphans = orphans
+	a.mu.Unlock()
+
+	for resName, kinds := range orphans {
+		if _, ok := last[resName]; !ok {
+			a.nodeEvent(v1.EventTypeWarning, "Orphan", "%s: orphaned %s on %s", resName, strings.Join(kinds, ","), a.node)
+			continue
+		}
+		if remove {
+			a.collect(resName, kinds, dryRun)
+		}
+	}
+}
+
+// wanted returns the resources which should exist on this node, those of the
+// pvs on this node and those of the pvcs being provisioned.
+func (a *Agent) wanted() (map[string]bool, error) {
+	wanted := map[string]bool{}
+
+	pvs, err := a.client.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, pv := range pvs.Items {
+		if provisioner := pv.Annotations[defs.AnnCreatedBy]; provisioner != defs.DrbdDriver {
+			continue
+		}
+		diskless := strings.Split(pv.Annotations[defs.AnnDiskless], ",")
+		if res.Contains(pvHosts(&pv), a.node) || res.Contains(diskless, a.node) {
+			wanted[pv.Name] = true
+		}
+	}
+
+	// Provisioned, the pv may not have been created yet, see stor journal
+	resources, err := crd.List(a.client.CoreV1().RESTClient())
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	for _, r := range resources {
+		for _, n := range r.Spec.Nodes {
+			if n.Name == a.node {
+				wanted[r.Name] = true
+			}
+		}
+	}
+
+	// Provisioning progress journal, see stor
+	pvcs, err := a.client.CoreV1().PersistentVolumeClaims("").List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, pvc := range pvcs.Items {
+		data, ok := pvc.Annotations[defs.AnnJournal]
+		if !ok {
+			continue
+		}
+		var j struct {
+			Hosts []string `json:"hosts"`
+		}
+		// Keep everything of a journal we don't understand
+		if err := json.Unmarshal([]byte(data), &j); err != nil || res.Contains(j.Hosts, a.node) {
+			wanted[pvc.Namespace+"-"+pvc.Name] = true
+		}
+	}
+
+	return wanted, nil
+}
+
+// collect removes orphan resName from this node. A resource which is Primary
+// is in use, it is never removed.
+func (a *Agent) collect(resName string, kinds []string, dryRun bool) {
+	if status, err := drbdadm.Status(resName); err == nil && status.Role == drbdadm.RolePrimary {
+		a.nodeEvent(v1.EventTypeWarning, "OrphanInUse", "%s: orphan is Primary on %s, not removed", resName, a.node)
+		return
+	}
+
+	if dryRun {
+		glog.Infof("gc: dry run, would remove %s of %s", strings.Join(kinds, ","), resName)
+		return
+	}
+
+	for _, kind := range []string{orphanResource, orphanConfig, orphanDisk} {
+		if !res.Contains(kinds, kind) {
+			continue
+		}
+
+		var err error
+		switch kind {
+		case orphanResource:
+			err = drbdadm.Down(resName)
+		case orphanConfig:
+			err = res.Del(resName)
+		case orphanDisk:
+			if err = crypt.Close(crypt.BelowName(resName)); err == nil {
+				err = lvm.Remove(path.Join("/dev", defs.DrbdDiskVG, resName))
+			}
+		}
+		if err != nil {
+			a.nodeEvent(v1.EventTypeWarning, "OrphanRemoveFailed", "%s: remove %s on %s: %v", resName, kind, a.node, err)
+			return
+		}
+
+		a.mu.Lock()
+		a.removed[kind]++
+		a.mu.Unlock()
+	}
+
+	a.nodeEvent(v1.EventTypeNormal, "OrphanRemoved", "%s: removed %s on %s", resName, strings.Join(kinds, ","), a.node)
+}
+
+// nodeEvent records an event on the node of this agent
+func (a *Agent) nodeEvent(eventtype, reason, messageFmt string, args ...interface{}) {
+	ref := &v1.ObjectReference{Kind: "Node", Name: a.node, UID: types.UID(a.node)}
+	a.recorder.Eventf(ref, eventtype, reason, messageFmt, args...)
+}
+
+// pvHosts returns the hosts in the node affinity of pv
+func pvHosts(pv *v1.PersistentVolume) []string {
+	var hosts []string
+	if affinity := pv.Spec.NodeAffinity; affinity != nil && affinity.Required != nil {
+		for _, term := range affinity.Required.NodeSelectorTerms {
+			for _, expr := range term.MatchExpressions {
+				hosts = append(hosts, expr.Values...)
+			}
+		}
+	}
+	return hosts
+}
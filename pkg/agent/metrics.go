@@ -0,0 +1,170 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package agent
+
+import (
+	"strconv"
+
+	"github.com/ctriple/drbd/pkg/drbdadm"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "drbd"
+)
+
+var (
+	resLabels     = []string{"resource", "pv", "pvc"}
+	devLabels     = with(resLabels, "volume")
+	peerLabels    = with(resLabels, "peer")
+	peerDevLabels = with(peerLabels, "volume")
+
+	roleDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "resource", "role"),
+		"Role of the resource on this node, 1 for the current role.", with(resLabels, "role"), nil)
+	diskStateDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "device", "disk_state"),
+		"Local disk state of the volume, 1 for the current state.", with(devLabels, "state"), nil)
+	alWritesDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "device", "al_writes_total"),
+		"Activity log updates of the volume.", devLabels, nil)
+	alSuspendedDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "device", "al_suspended"),
+		"1 if activity log updates of the volume are suspended.", devLabels, nil)
+	upperPendingDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "device", "upper_pending"),
+		"Application requests of the volume not yet completed.", devLabels, nil)
+	lowerPendingDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "device", "lower_pending"),
+		"Backing disk requests of the volume not yet completed.", devLabels, nil)
+
+	connStateDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "connection", "state"),
+		"Connection state to the peer, 1 for the current state.", with(peerLabels, "state"), nil)
+	peerDiskStateDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "peer_device", "disk_state"),
+		"Disk state of the volume on the peer, 1 for the current state.", with(peerDevLabels, "state"), nil)
+	outOfSyncDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "peer_device", "out_of_sync_kib"),
+		"Data of the volume known out of sync with the peer.", peerDevLabels, nil)
+	resyncRateDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "peer_device", "resync_rate_kib_per_second"),
+		"Recent resync rate of the volume with the peer.", peerDevLabels, nil)
+	sentDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "peer_device", "sent_kib_total"),
+		"Data of the volume sent to the peer.", peerDevLabels, nil)
+	receivedDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "peer_device", "received_kib_total"),
+		"Data of the volume received from the peer.", peerDevLabels, nil)
+	pendingDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "peer_device", "pending"),
+		"Requests of the volume sent to the peer but not yet answered.", peerDevLabels, nil)
+	unackedDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "peer_device", "unacked"),
+		"Requests of the volume received from the peer but not yet answered.", peerDevLabels, nil)
+
+	verifyOutOfSyncDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "verify", "out_of_sync_kib"),
+		"Data of the volume found out of sync with the peer by the last online verify.", peerLabels, nil)
+	verifyMismatchesDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "verify", "mismatches_total"),
+		"Online verifies which found data out of sync with a peer.", resLabels, nil)
+
+	orphanDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "gc", "orphan"),
+		"1 for what an orphaned resource left behind on this node.", []string{"resource", "kind"}, nil)
+	orphanRemovedDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "gc", "removed_total"),
+		"Orphans removed from this node.", []string{"kind"}, nil)
+
+	driftCorrectionsDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "drift", "corrections_total"),
+		"Resource files rewritten and running resources adjusted on this node since they drifted from spec.", []string{"kind"}, nil)
+)
+
+// collector exports status of all drbd resources on this node, labelled with
+// the pv and pvc which the resources back.
+type collector struct {
+	agent *Agent
+}
+
+// Collector returns the prometheus collector of this agent
+func (a *Agent) Collector() prometheus.Collector {
+	return collector{agent: a}
+}
+
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		roleDesc, diskStateDesc, alWritesDesc, alSuspendedDesc, upperPendingDesc, lowerPendingDesc,
+		connStateDesc, peerDiskStateDesc, outOfSyncDesc, resyncRateDesc, sentDesc, receivedDesc, pendingDesc, unackedDesc,
+		verifyOutOfSyncDesc, verifyMismatchesDesc, orphanDesc, orphanRemovedDesc, driftCorrectionsDesc,
+	} {
+		ch <- desc
+	}
+}
+
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	c.agent.mu.Lock()
+	for resName, kinds := range c.agent.orphans {
+		for _, kind := range kinds {
+			ch <- prometheus.MustNewConstMetric(orphanDesc, prometheus.GaugeValue, 1, resName, kind)
+		}
+	}
+	for kind, n := range c.agent.removed {
+		ch <- prometheus.MustNewConstMetric(orphanRemovedDesc, prometheus.CounterValue, float64(n), kind)
+	}
+	for kind, n := range c.agent.corrections {
+		ch <- prometheus.MustNewConstMetric(driftCorrectionsDesc, prometheus.CounterValue, float64(n), kind)
+	}
+	for resName, mismatch := range c.agent.mismatch {
+		res := []string{resName, resName, c.agent.claims[resName]}
+		for peer, kib := range mismatch {
+			ch <- prometheus.MustNewConstMetric(verifyOutOfSyncDesc, prometheus.GaugeValue, float64(kib), with(res, peer)...)
+		}
+		ch <- prometheus.MustNewConstMetric(verifyMismatchesDesc, prometheus.CounterValue, float64(c.agent.mismatches[resName]), res...)
+	}
+	c.agent.mu.Unlock()
+
+	resNames, err := drbdadm.ShResources()
+	if err != nil {
+		glog.Errorln("sh-resources:", err)
+		return
+	}
+
+	for _, resName := range resNames {
+		status, err := drbdadm.Status(resName)
+		if err != nil {
+			continue
+		}
+		res := []string{resName, resName, c.agent.claim(resName)}
+
+		ch <- stateMetric(roleDesc, status.Role, res)
+		for _, d := range status.Devices {
+			dev := with(res, strconv.Itoa(d.Volume))
+
+			ch <- stateMetric(diskStateDesc, d.DiskState, dev)
+			ch <- prometheus.MustNewConstMetric(alWritesDesc, prometheus.CounterValue, float64(d.ALWrites), dev...)
+			ch <- prometheus.MustNewConstMetric(alSuspendedDesc, prometheus.GaugeValue, boolValue(d.ALSuspended), dev...)
+			ch <- prometheus.MustNewConstMetric(upperPendingDesc, prometheus.GaugeValue, float64(d.UpperPending), dev...)
+			ch <- prometheus.MustNewConstMetric(lowerPendingDesc, prometheus.GaugeValue, float64(d.LowerPending), dev...)
+		}
+
+		for _, conn := range status.Connections {
+			peer := with(res, conn.Name)
+
+			ch <- stateMetric(connStateDesc, conn.ConnectionState, peer)
+			for _, pd := range conn.PeerDevices {
+				peerDev := with(peer, strconv.Itoa(pd.Volume))
+
+				ch <- stateMetric(peerDiskStateDesc, pd.PeerDiskState, peerDev)
+				ch <- prometheus.MustNewConstMetric(outOfSyncDesc, prometheus.GaugeValue, float64(pd.OutOfSync), peerDev...)
+				ch <- prometheus.MustNewConstMetric(resyncRateDesc, prometheus.GaugeValue, pd.ResyncRate(), peerDev...)
+				ch <- prometheus.MustNewConstMetric(sentDesc, prometheus.CounterValue, float64(pd.Sent), peerDev...)
+				ch <- prometheus.MustNewConstMetric(receivedDesc, prometheus.CounterValue, float64(pd.Received), peerDev...)
+				ch <- prometheus.MustNewConstMetric(pendingDesc, prometheus.GaugeValue, float64(pd.Pending), peerDev...)
+				ch <- prometheus.MustNewConstMetric(unackedDesc, prometheus.GaugeValue, float64(pd.Unacked), peerDev...)
+			}
+		}
+	}
+}
+
+// stateMetric reports the current state by value 1, the state is the last
+// label of desc.
+func stateMetric(desc *prometheus.Desc, state string, labels []string) prometheus.Metric {
+	return prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, with(labels, state)...)
+}
+
+// with returns a copy of labels with more labels appended
+func with(labels []string, more ...string) []string {
+	return append(append([]string{}, labels...), more...)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
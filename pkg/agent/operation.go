@@ -0,0 +1,168 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/ctriple/drbd/pkg/crd"
+	"github.com/ctriple/drbd/pkg/crypt"
+	"github.com/ctriple/drbd/pkg/defs"
+	"github.com/ctriple/drbd/pkg/drbdadm"
+	"github.com/ctriple/drbd/pkg/sync/lvm"
+	"github.com/ctriple/drbd/pkg/sync/res"
+	"github.com/golang/glog"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Operations the agent runs on a resource of its node on request, see
+// kubectl-drbd.
+const (
+	OpPromote    = "promote"
+	OpDemote     = "demote"
+	OpConnect    = "connect"
+	OpDisconnect = "disconnect"
+	OpVerify     = "verify"
+	OpInvalidate = "invalidate"
+	OpLeave      = "leave"
+)
+
+// Operation asks the agent on Node to run Op on the resource of the annotated
+// pv. Peer limits connect, disconnect and verify to one peer, all peers if
+// empty. Leave removes the replica of a node moved away, see Agent.leave.
+type Operation struct {
+	Op   string `json:"op"`
+	Node string `json:"node"`
+	Peer string `json:"peer,omitempty"`
+}
+
+// OperationResult is the outcome of the last operation on a resource
+type OperationResult struct {
+	Operation
+	Error string      `json:"error,omitempty"`
+	Time  metav1.Time `json:"time"`
+}
+
+func (op Operation) String() string {
+	if op.Peer != "" {
+		return fmt.Sprintf("%s %s on %s", op.Op, op.Peer, op.Node)
+	}
+	return fmt.Sprintf("%s on %s", op.Op, op.Node)
+}
+
+// operate runs the operation requested on pv if it is meant for this node
+func (a *Agent) operate(pv *v1.PersistentVolume) {
+	data, ok := pv.Annotations[defs.AnnOperation]
+	if !ok {
+		return
+	}
+
+	op := Operation{}
+	if err := json.Unmarshal([]byte(data), &op); err != nil {
+		glog.Warningf("%s: %s: %v", pv.Name, defs.AnnOperation, err)
+		return
+	}
+	if op.Node != a.node {
+		return
+	}
+
+	var err error
+	if op.Op == OpLeave {
+		err = a.leave(pv.Name)
+	} else {
+		err = runOperation(pv.Name, op)
+	}
+	if err != nil {
+		a.event(pv, v1.EventTypeWarning, "OperationFailed", "%s: %s: %v", pv.Name, op, err)
+	} else {
+		a.event(pv, v1.EventTypeNormal, "OperationSucceeded", "%s: %s", pv.Name, op)
+	}
+
+	if err := a.finish(pv.Name, data, op, err); err != nil {
+		glog.Errorf("%s: finish %s: %v", pv.Name, op, err)
+	}
+}
+
+func runOperation(resName string, op Operation) error {
+	switch op.Op {
+	case OpPromote:
+		return drbdadm.Primary(resName)
+	case OpDemote:
+		return drbdadm.Secondary(resName)
+	case OpConnect:
+		return drbdadm.Connect(resName, op.Peer, false)
+	case OpDisconnect:
+		return drbdadm.Disconnect(resName, op.Peer)
+	case OpVerify:
+		return drbdadm.Verify(resName, op.Peer)
+	case OpInvalidate:
+		return drbdadm.Invalidate(resName)
+	default:
+		return fmt.Errorf("unknown operation %q", op.Op)
+	}
+}
+
+// leave removes the replica of resource on this node once the node was
+// replaced in the spec of the resource, such as by kubectl drbd move: the
+// resource is brought down, its resource file and backing disk are removed.
+// A replica still in the spec or Primary is never removed.
+func (a *Agent) leave(resName string) error {
+	r, err := crd.Get(a.client.CoreV1().RESTClient(), resName)
+	if err != nil {
+		return err
+	}
+	for _, n := range r.Spec.Nodes {
+		if n.Name == a.node {
+			return fmt.Errorf("%s is still a node of %s", a.node, resName)
+		}
+	}
+
+	if status, err := drbdadm.Status(resName); err == nil {
+		if status.Role == drbdadm.RolePrimary {
+			return fmt.Errorf("%s is Primary on %s", resName, a.node)
+		}
+		if err := drbdadm.Down(resName); err != nil {
+			return err
+		}
+	}
+	if err := res.Del(resName); err != nil {
+		return err
+	}
+	if err := crypt.Close(crypt.BelowName(resName)); err != nil {
+		return err
+	}
+	return lvm.Remove(path.Join("/dev", defs.DrbdDiskVG, resName))
+}
+
+// finish replaces the operation request of pv with its result, unless it was
+// replaced by a new request meanwhile.
+func (a *Agent) finish(pvName, data string, op Operation, opErr error) error {
+	pvClient := a.client.CoreV1().PersistentVolumes()
+
+	pv, err := pvClient.Get(pvName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if pv.Annotations[defs.AnnOperation] != data {
+		return nil
+	}
+
+	result := OperationResult{Operation: op, Time: metav1.Now()}
+	if opErr != nil {
+		result.Error = opErr.Error()
+	}
+	out, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	delete(pv.Annotations, defs.AnnOperation)
+	pv.Annotations[defs.AnnOperationResult] = string(out)
+
+	_, err = pvClient.Update(pv)
+	return err
+}
@@ -0,0 +1,255 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/ctriple/drbd/pkg/crypt"
+	"github.com/ctriple/drbd/pkg/defs"
+	"github.com/ctriple/drbd/pkg/drbdadm"
+	"github.com/ctriple/drbd/pkg/sync/lvm"
+	"github.com/ctriple/drbd/pkg/sync/res"
+	"github.com/golang/glog"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Phases of a node rejoining, in order
+const (
+	rejoinConnect  = "connect"  // connecting diskless to the peers
+	rejoinDisk     = "disk"     // recreating the backing disk
+	rejoinMetadata = "metadata" // creating DRBD metadata
+	rejoinAttach   = "attach"   // attaching the backing disk
+	rejoinResync   = "resync"   // full resync from the peers
+)
+
+// rejoinProgress of a node, the values of defs.AnnRejoin
+type rejoinProgress struct {
+	Phase   string  `json:"phase"`
+	Percent float64 `json:"percent,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// rejoin recreates the backing disk of resource on this node if it is lost,
+// such as after the disk was replaced, and attaches it to let DRBD resync all
+// the data from the peers. The progress is kept on the pv until the disk is
+// UpToDate, so a rejoin interrupted by a restart is resumed.
+//
+// Only a missing disk is recreated, and only attached once a connected peer
+// is UpToDate, a resource which is down is brought up diskless to connect
+// first. A disk which is there but detached, such as on io errors, is left to
+// the admin. So is a node which is not a replica of pv, such as a diskless one
+// or one the replica moved away from, and a pv which is not bound.
+func (a *Agent) rejoin(pv *v1.PersistentVolume) {
+	if pv.Status.Phase != v1.VolumeBound || pv.DeletionTimestamp != nil {
+		return
+	}
+	if !res.Contains(pvHosts(pv), a.node) {
+		return
+	}
+	resName := pv.Name
+
+	status, err := drbdadm.Status(resName)
+	up := err == nil
+
+	progress, rejoining := rejoins(pv)[a.node]
+	if !rejoining {
+		if up && !diskless(status) {
+			return
+		}
+		if lvm.Exists(path.Join("/dev", defs.DrbdDiskVG, resName)) {
+			return
+		}
+
+		progress = rejoinProgress{Phase: rejoinConnect}
+		if err := a.setRejoin(pv, &progress); err != nil {
+			glog.Warningf("%s: rejoin: %v", resName, err)
+			return
+		}
+		a.event(pv, v1.EventTypeWarning, "RejoinStarted", "%s: backing disk on %s missing, recreating it for a full resync", resName, a.node)
+	}
+
+	if !up {
+		if err := drbdadm.UpDiskless(resName); err != nil {
+			a.setRejoin(pv, &rejoinProgress{Phase: rejoinConnect, Error: err.Error()})
+			a.event(pv, v1.EventTypeWarning, "RejoinFailed", "%s: %s on %s: %v", resName, rejoinConnect, a.node, err)
+		}
+		return
+	}
+
+	if diskless(status) {
+		// Nothing to resync from yet
+		if !upToDatePeer(status) {
+			glog.V(2).Infof("%s: backing disk missing, waiting for a connected UpToDate peer", resName)
+			return
+		}
+		if phase, err := a.recreate(pv); err != nil {
+			a.setRejoin(pv, &rejoinProgress{Phase: phase, Error: err.Error()})
+			a.event(pv, v1.EventTypeWarning, "RejoinFailed", "%s: %s on %s: %v", resName, phase, a.node, err)
+			return
+		}
+		a.setRejoin(pv, &rejoinProgress{Phase: rejoinResync})
+		a.event(pv, v1.EventTypeNormal, "RejoinAttached", "%s: new backing disk on %s attached, resyncing from peers", resName, a.node)
+		return
+	}
+
+	if upToDate(status) {
+		if err := a.setRejoin(pv, nil); err != nil {
+			glog.Warningf("%s: rejoin: %v", resName, err)
+			return
+		}
+		a.event(pv, v1.EventTypeNormal, "Rejoined", "%s: %s resynced from peers, UpToDate", resName, a.node)
+		return
+	}
+
+	// Only update the pv as the resync progresses by a percent
+	percent, ok := status.SyncTarget()
+	if !ok || (progress.Phase == rejoinResync && int(percent) == int(progress.Percent)) {
+		return
+	}
+	a.setRejoin(pv, &rejoinProgress{Phase: rejoinResync, Percent: percent})
+}
+
+// recreate creates the backing disk of resource as the sync job does, and
+// attaches it to the resource, which is up diskless. Each step is skipped if
+// already done, so a failed recreate is retried. It returns the phase which
+// failed.
+func (a *Agent) recreate(pv *v1.PersistentVolume) (string, error) {
+	resName := pv.Name
+	disk := path.Join("/dev", defs.DrbdDiskVG, resName)
+
+	if !lvm.Exists(disk) {
+		if err := lvm.Create(defs.DrbdDiskVG, resName, diskSize(pv)); err != nil {
+			return rejoinDisk, err
+		}
+	}
+
+	if pv.Annotations[defs.AnnEncryption] == defs.Encryption_Below {
+		key, err := a.encryptionKey(pv)
+		if err != nil {
+			return rejoinDisk, err
+		}
+		name := crypt.BelowName(resName)
+		luks, err := crypt.IsLuks(disk)
+		if err != nil {
+			return rejoinDisk, err
+		}
+		if !luks {
+			if err := crypt.Format(disk, key); err != nil {
+				return rejoinDisk, err
+			}
+		}
+		if !crypt.Opened(name) {
+			if err := crypt.Open(disk, name, key); err != nil {
+				return rejoinDisk, err
+			}
+		}
+	}
+
+	// The disk was never attached, there is no data on it yet
+	if err := drbdadm.CreateMD(resName); err != nil {
+		return rejoinMetadata, err
+	}
+	if err := drbdadm.Attach(resName); err != nil {
+		return rejoinAttach, err
+	}
+
+	return "", nil
+}
+
+// diskSize returns the size of the backing disk of pv, as stor has it
+func diskSize(pv *v1.PersistentVolume) string {
+	capacity := pv.Spec.Capacity[v1.ResourceStorage]
+	mb := capacity.Value()/1024/1024 + 1
+	if pv.Annotations[defs.AnnEncryption] != "" {
+		mb += defs.EncryptionHeaderMB
+	}
+	return fmt.Sprintf("%dM", mb)
+}
+
+// rejoins returns the progress of the nodes of pv rejoining
+func rejoins(pv *v1.PersistentVolume) map[string]rejoinProgress {
+	progress := map[string]rejoinProgress{}
+	if ann := pv.Annotations[defs.AnnRejoin]; ann != "" {
+		if err := json.Unmarshal([]byte(ann), &progress); err != nil {
+			glog.Warningf("%s: %s: %v", pv.Name, defs.AnnRejoin, err)
+		}
+	}
+	return progress
+}
+
+// setRejoin saves the progress of this node on pv, nil when it rejoined.
+func (a *Agent) setRejoin(pv *v1.PersistentVolume, progress *rejoinProgress) error {
+	pvClient := a.client.CoreV1().PersistentVolumes()
+
+	latest, err := pvClient.Get(pv.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	all := rejoins(latest)
+	if progress == nil {
+		delete(all, a.node)
+	} else {
+		all[a.node] = *progress
+	}
+
+	if len(all) == 0 {
+		delete(latest.Annotations, defs.AnnRejoin)
+	} else {
+		data, err := json.Marshal(all)
+		if err != nil {
+			return err
+		}
+		if latest.Annotations == nil {
+			latest.Annotations = map[string]string{}
+		}
+		latest.Annotations[defs.AnnRejoin] = string(data)
+	}
+
+	updated, err := pvClient.Update(latest)
+	if err != nil {
+		return err
+	}
+	pv.Annotations = updated.Annotations
+	return nil
+}
+
+// diskless returns true if a volume of resource has no disk on this node
+func diskless(status drbdadm.ResStatus) bool {
+	for _, d := range status.Devices {
+		if d.DiskState == drbdadm.DiskDiskless {
+			return true
+		}
+	}
+	return false
+}
+
+// upToDate returns true if all volumes of resource are UpToDate on this node
+func upToDate(status drbdadm.ResStatus) bool {
+	for _, d := range status.Devices {
+		if d.DiskState != drbdadm.DiskUpToDate {
+			return false
+		}
+	}
+	return len(status.Devices) > 0
+}
+
+// upToDatePeer returns true if a connected peer has UpToDate data
+func upToDatePeer(status drbdadm.ResStatus) bool {
+	for _, c := range status.Connections {
+		if c.ConnectionState != drbdadm.ConnConnected {
+			continue
+		}
+		for _, pd := range c.PeerDevices {
+			if pd.PeerDiskState == drbdadm.DiskUpToDate {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package agent
+
+import (
+	"testing"
+
+	"github.com/ctriple/drbd/pkg/defs"
+	"github.com/ctriple/drbd/pkg/drbdadm"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRejoinStatus(t *testing.T) {
+	status := drbdadm.ResStatus{
+		Devices: []drbdadm.DevStatus{{DiskState: drbdadm.DiskDiskless}},
+		Connections: []drbdadm.ConnStatus{
+			peer(0, "node1", drbdadm.RoleSecondary, drbdadm.DiskUpToDate),
+		},
+	}
+	if !diskless(status) || upToDate(status) || !upToDatePeer(status) {
+		t.Fatal("expect diskless with an UpToDate peer")
+	}
+
+	status.Connections[0].ConnectionState = drbdadm.ConnStandAlone
+	if upToDatePeer(status) {
+		t.Fatal("expect no UpToDate peer when disconnected")
+	}
+
+	status.Devices[0].DiskState = drbdadm.DiskUpToDate
+	if diskless(status) || !upToDate(status) {
+		t.Fatal("expect UpToDate")
+	}
+}
+
+func TestDiskSize(t *testing.T) {
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity: v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")},
+		},
+	}
+	if size := diskSize(pv); size != "1025M" {
+		t.Fatalf("unexpected size: %s", size)
+	}
+
+	pv.Annotations[defs.AnnEncryption] = defs.Encryption_Below
+	if size := diskSize(pv); size != "1041M" {
+		t.Fatalf("unexpected encrypted size: %s", size)
+	}
+}
+
+func TestRejoins(t *testing.T) {
+	pv := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		defs.AnnRejoin: `{"node1":{"phase":"resync","percent":42.5}}`,
+	}}}
+	progress, ok := rejoins(pv)["node1"]
+	if !ok || progress.Phase != rejoinResync || progress.Percent != 42.5 {
+		t.Fatalf("unexpected progress: %+v", progress)
+	}
+	if _, ok := rejoins(pv)["node2"]; ok {
+		t.Fatal("node2 not rejoining")
+	}
+}
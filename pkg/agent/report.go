@@ -0,0 +1,84 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package agent
+
+import (
+	"reflect"
+
+	"github.com/ctriple/drbd/pkg/crd"
+	"github.com/ctriple/drbd/pkg/drbdadm"
+	"github.com/golang/glog"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// report publishes the status of resource on this node in its DrbdResource,
+// unless it is unchanged since the last report. Resources provisioned before
+// DrbdResource was introduced have none, they are not reported.
+func (a *Agent) report(resName string, status drbdadm.ResStatus) {
+	s := crd.DrbdNodeStatus{
+		Role:        status.Role,
+		Connections: map[string]string{},
+		InSync:      true,
+	}
+	if len(status.Devices) > 0 {
+		s.DiskState = status.Devices[0].DiskState
+	}
+	for _, c := range status.Connections {
+		s.Connections[c.Name] = c.ConnectionState
+		if c.ConnectionState != drbdadm.ConnConnected {
+			s.InSync = false
+		}
+		for _, pd := range c.PeerDevices {
+			s.OutOfSyncKiB += pd.OutOfSync
+			if pd.PeerDiskState != drbdadm.DiskUpToDate && pd.PeerDiskState != drbdadm.DiskDiskless {
+				s.InSync = false
+			}
+		}
+	}
+	if s.OutOfSyncKiB > 0 {
+		s.InSync = false
+	}
+
+	a.mu.Lock()
+	last, ok := a.reported[resName]
+	a.mu.Unlock()
+
+	// Reported before this agent started
+	if !ok {
+		r, err := crd.Get(a.client.CoreV1().RESTClient(), resName)
+		if err != nil {
+			glog.V(4).Infof("%s: report status: %v", resName, err)
+			return
+		}
+		last, ok = r.Status.Nodes[a.node]
+	}
+	if ok && sameStatus(last, s) {
+		return
+	}
+
+	now := metav1.Now()
+	s.UpdateTime = now
+	s.LastSyncTime = last.LastSyncTime
+	if s.InSync && !last.InSync {
+		s.LastSyncTime = &now
+	}
+
+	if err := crd.PatchStatus(a.client.CoreV1().RESTClient(), resName, a.node, s); err != nil {
+		glog.V(4).Infof("%s: report status: %v", resName, err)
+		return
+	}
+
+	a.mu.Lock()
+	a.reported[resName] = s
+	a.mu.Unlock()
+}
+
+// sameStatus compares status regardless of when it was taken
+func sameStatus(a, b crd.DrbdNodeStatus) bool {
+	a.LastSyncTime, b.LastSyncTime = nil, nil
+	a.UpdateTime, b.UpdateTime = metav1.Time{}, metav1.Time{}
+
+	return reflect.DeepEqual(a, b)
+}
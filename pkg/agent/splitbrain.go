@@ -0,0 +1,156 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/ctriple/drbd/pkg/defs"
+	"github.com/ctriple/drbd/pkg/drbdadm"
+	"github.com/ctriple/drbd/pkg/sync/res"
+	"github.com/golang/glog"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// splitBrainDir is where the DRBD split-brain handler leaves the peers of a
+// split brain, var for testing
+var splitBrainDir = defs.SplitBrainDir
+
+// splitBrain resolves split brain of resource on this node.
+//
+// DRBD leaves the connection StandAlone after a split brain it could not
+// resolve automatically, calls the split-brain handler, and refuses to
+// reconnect until somebody chooses the survivor whose data is kept. Only the
+// peers reported by the handler are split brain, a connection StandAlone for
+// another reason, such as kubectl drbd disconnect or online verify, is left
+// alone. The choice is made by annotating the pv with the survivor's node
+// name, then agents on the victims discard their data and reconnect, the
+// survivor reconnects and clears the annotation when healed.
+func (a *Agent) splitBrain(pv *v1.PersistentVolume, status drbdadm.ResStatus) {
+	resName := pv.Name
+	survivor := pv.Annotations[defs.AnnSplitBrainSurvivor]
+
+	var split []string
+	for _, peer := range splitBrainPeers(resName) {
+		state := ""
+		for _, c := range status.Connections {
+			if c.Name == peer {
+				state = c.ConnectionState
+			}
+		}
+
+		switch state {
+		// Healed, or the peer left the resource
+		case drbdadm.ConnConnected, "":
+			if err := clearSplitBrain(resName, peer); err != nil {
+				glog.Errorf("%s: %v", resName, err)
+				split = append(split, peer)
+			}
+		default:
+			split = append(split, peer)
+		}
+	}
+
+	if len(split) == 0 {
+		if survivor == a.node && status.Connected() {
+			if err := a.clearSurvivor(resName); err != nil {
+				glog.Errorf("%s: %v", resName, err)
+				return
+			}
+			a.event(pv, v1.EventTypeNormal, "SplitBrainResolved", "%s: %s reconnected to all peers", resName, a.node)
+		}
+		return
+	}
+
+	var standAlone []string
+	for _, c := range status.Connections {
+		if res.Contains(split, c.Name) && c.ConnectionState == drbdadm.ConnStandAlone {
+			standAlone = append(standAlone, c.Name)
+		}
+	}
+	// Reconnecting
+	if len(standAlone) == 0 {
+		return
+	}
+
+	if survivor == "" {
+		a.event(pv, v1.EventTypeWarning, "SplitBrain", "%s: %s is split brain with %v, annotate pv with %s=<node> to choose the survivor",
+			resName, a.node, standAlone, defs.AnnSplitBrainSurvivor)
+		return
+	}
+
+	// Survivor keeps its data
+	if survivor == a.node {
+		for _, peer := range standAlone {
+			if err := drbdadm.Connect(resName, peer, false); err != nil {
+				a.event(pv, v1.EventTypeWarning, "SplitBrainRecovery", "%s: survivor %s connect %s: %v", resName, a.node, peer, err)
+			}
+		}
+		return
+	}
+
+	// Victim discards its data and resyncs from the survivor
+	if status.Role == drbdadm.RolePrimary {
+		if err := drbdadm.Secondary(resName); err != nil {
+			a.event(pv, v1.EventTypeWarning, "SplitBrainRecovery", "%s: victim %s is Primary and in use: %v", resName, a.node, err)
+			return
+		}
+	}
+	for _, peer := range standAlone {
+		if err := drbdadm.Connect(resName, peer, peer == survivor); err != nil {
+			a.event(pv, v1.EventTypeWarning, "SplitBrainRecovery", "%s: victim %s connect %s: %v", resName, a.node, peer, err)
+			continue
+		}
+		if peer == survivor {
+			a.event(pv, v1.EventTypeNormal, "SplitBrainRecovery", "%s: victim %s discarded its data, resync from %s", resName, a.node, survivor)
+		}
+	}
+}
+
+// splitBrainPeers returns the peers the DRBD split-brain handler reported a
+// split brain of resource with, see flex driver split-brain action.
+func splitBrainPeers(resName string) []string {
+	infos, err := ioutil.ReadDir(path.Join(splitBrainDir, resName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			glog.Warningf("%s: %v", resName, err)
+		}
+		return nil
+	}
+
+	var peers []string
+	for _, info := range infos {
+		peers = append(peers, info.Name())
+	}
+	return peers
+}
+
+// clearSplitBrain forgets the split brain of resource with peer
+func clearSplitBrain(resName, peer string) error {
+	dir := path.Join(splitBrainDir, resName)
+	if err := os.Remove(path.Join(dir, peer)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Only removed once empty
+	os.Remove(dir)
+	return nil
+}
+
+// clearSurvivor removes the split brain survivor annotation of pv
+func (a *Agent) clearSurvivor(pvName string) error {
+	pvClient := a.client.CoreV1().PersistentVolumes()
+
+	pv, err := pvClient.Get(pvName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	delete(pv.Annotations, defs.AnnSplitBrainSurvivor)
+
+	_, err = pvClient.Update(pv)
+	return err
+}
@@ -0,0 +1,45 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestSplitBrainPeers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "split-brain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(saved string) { splitBrainDir = saved }(splitBrainDir)
+	splitBrainDir = dir
+
+	if peers := splitBrainPeers("ns-pvc"); peers != nil {
+		t.Fatalf("unexpected peers without split brain: %v", peers)
+	}
+
+	os.MkdirAll(path.Join(dir, "ns-pvc"), 0700)
+	for _, peer := range []string{"node1", "node2"} {
+		if err := ioutil.WriteFile(path.Join(dir, "ns-pvc", peer), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if peers := splitBrainPeers("ns-pvc"); !reflect.DeepEqual(peers, []string{"node1", "node2"}) {
+		t.Fatalf("unexpected peers: %v", peers)
+	}
+
+	for _, peer := range []string{"node1", "node2"} {
+		if err := clearSplitBrain("ns-pvc", peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(path.Join(dir, "ns-pvc")); !os.IsNotExist(err) {
+		t.Fatalf("expect resource dir removed: %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package agent
+
+import (
+	"time"
+
+	"github.com/ctriple/drbd/pkg/defs"
+	"github.com/ctriple/drbd/pkg/drbdadm"
+	"github.com/ctriple/drbd/pkg/sync/res"
+	"github.com/golang/glog"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EnableVerify makes the agent run online verify of every resource every
+// interval, limited to rate per second such as 20M if not empty. Blocks found
+// out of sync are resynced if resync is set, otherwise only reported.
+func (a *Agent) EnableVerify(interval time.Duration, rate string, resync bool) {
+	a.verifyInterval = interval
+	a.verifyRate = rate
+	a.verifyResync = resync
+}
+
+// verify runs online verify of resource if this node is its verifier, see
+// verifier. Verify runs in the background, one resource at a time on each
+// node, its result is checked in the following syncs.
+func (a *Agent) verify(pv *v1.PersistentVolume, status drbdadm.ResStatus) {
+	if a.verifyInterval <= 0 || !verifier(status) {
+		return
+	}
+	resName := pv.Name
+
+	a.mu.Lock()
+	started, running := a.verifying[resName]
+	busy := len(a.verifying) > 0
+	a.mu.Unlock()
+
+	if running {
+		if status.Verifying() {
+			return
+		}
+		a.verified(pv, status, started)
+		return
+	}
+
+	if busy || status.Verifying() {
+		return
+	}
+	if last, err := time.Parse(time.RFC3339, pv.Annotations[defs.AnnLastVerify]); err == nil && time.Since(last) < a.verifyInterval {
+		return
+	}
+
+	// Anything out of sync before would be taken for a mismatch
+	peers := verifyPeers(status)
+	if len(peers) == 0 || !status.Connected() || outOfSync(status, peers) > 0 {
+		return
+	}
+
+	if a.verifyRate != "" {
+		if err := drbdadm.ResyncRate(resName, a.verifyRate); err != nil {
+			glog.Warningf("%s: verify rate: %v", resName, err)
+		}
+	}
+	for _, peer := range peers {
+		if err := drbdadm.Verify(resName, peer); err != nil {
+			a.event(pv, v1.EventTypeWarning, "VerifyFailed", "%s: verify %s against %s: %v", resName, a.node, peer, err)
+			drbdadm.Adjust(resName)
+			return
+		}
+	}
+
+	a.mu.Lock()
+	a.verifying[resName] = time.Now()
+	a.mu.Unlock()
+	glog.Infof("%s: verify against %v started", resName, peers)
+}
+
+// verified reports the result of a finished online verify
+func (a *Agent) verified(pv *v1.PersistentVolume, status drbdadm.ResStatus, started time.Time) {
+	resName := pv.Name
+	peers := verifyPeers(status)
+
+	mismatch := map[string]uint64{}
+	for _, c := range status.Connections {
+		if !res.Contains(peers, c.Name) {
+			continue
+		}
+		for _, pd := range c.PeerDevices {
+			mismatch[c.Name] += pd.OutOfSync
+		}
+	}
+
+	a.mu.Lock()
+	delete(a.verifying, resName)
+	a.mismatch[resName] = mismatch
+	a.mu.Unlock()
+
+	// Restore the configured resync rate
+	if a.verifyRate != "" {
+		if err := drbdadm.Adjust(resName); err != nil {
+			glog.Warningf("%s: restore resync rate: %v", resName, err)
+		}
+	}
+
+	for peer, kib := range mismatch {
+		if kib == 0 {
+			continue
+		}
+
+		a.mu.Lock()
+		a.mismatches[resName]++
+		a.mu.Unlock()
+
+		if !a.verifyResync {
+			a.event(pv, v1.EventTypeWarning, "VerifyMismatch", "%s: %dKiB differ between %s and %s", resName, kib, a.node, peer)
+			continue
+		}
+
+		// Out of sync blocks are resynced on reconnect
+		a.event(pv, v1.EventTypeWarning, "VerifyMismatch", "%s: %dKiB differ between %s and %s, resync", resName, kib, a.node, peer)
+		if err := drbdadm.Disconnect(resName, peer); err != nil {
+			a.event(pv, v1.EventTypeWarning, "VerifyResyncFailed", "%s: disconnect %s: %v", resName, peer, err)
+			continue
+		}
+		if err := drbdadm.Connect(resName, peer, false); err != nil {
+			a.event(pv, v1.EventTypeWarning, "VerifyResyncFailed", "%s: connect %s: %v", resName, peer, err)
+		}
+	}
+
+	a.event(pv, v1.EventTypeNormal, "Verified", "%s: verify of %s against %v finished in %v", resName, a.node, peers, time.Since(started).Round(time.Second))
+	if err := a.setLastVerify(resName, time.Now()); err != nil {
+		glog.Errorf("%s: %v", resName, err)
+	}
+}
+
+func (a *Agent) setLastVerify(pvName string, t time.Time) error {
+	pvClient := a.client.CoreV1().PersistentVolumes()
+
+	pv, err := pvClient.Get(pvName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	pv.Annotations[defs.AnnLastVerify] = t.Format(time.RFC3339)
+
+	_, err = pvClient.Update(pv)
+	return err
+}
+
+// verifier returns true if this node runs online verify of the resource: the
+// Primary, or without Primary the node with disk of the lowest node id.
+func verifier(status drbdadm.ResStatus) bool {
+	if status.Role == drbdadm.RolePrimary {
+		return true
+	}
+	for _, d := range status.Devices {
+		if d.DiskState == drbdadm.DiskDiskless {
+			return false
+		}
+	}
+	for _, c := range status.Connections {
+		if c.PeerRole == drbdadm.RolePrimary {
+			return false
+		}
+		if res.Contains(verifyPeers(status), c.Name) && c.PeerNodeID < status.NodeID {
+			return false
+		}
+	}
+
+	return true
+}
+
+// verifyPeers returns the peers with disk
+func verifyPeers(status drbdadm.ResStatus) []string {
+	var peers []string
+	for _, c := range status.Connections {
+		for _, pd := range c.PeerDevices {
+			if pd.PeerDiskState != drbdadm.DiskDiskless {
+				peers = append(peers, c.Name)
+				break
+			}
+		}
+	}
+	return peers
+}
+
+func outOfSync(status drbdadm.ResStatus, peers []string) uint64 {
+	var kib uint64
+	for _, c := range status.Connections {
+		if !res.Contains(peers, c.Name) {
+			continue
+		}
+		for _, pd := range c.PeerDevices {
+			kib += pd.OutOfSync
+		}
+	}
+	return kib
+}
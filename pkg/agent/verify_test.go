@@ -0,0 +1,53 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package agent
+
+import (
+	"testing"
+
+	"github.com/ctriple/drbd/pkg/drbdadm"
+)
+
+func peer(id int, name, role, disk string) drbdadm.ConnStatus {
+	return drbdadm.ConnStatus{
+		PeerNodeID:      id,
+		Name:            name,
+		ConnectionState: drbdadm.ConnConnected,
+		PeerRole:        role,
+		PeerDevices:     []drbdadm.PeerDevStatus{{PeerDiskState: disk}},
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	status := drbdadm.ResStatus{
+		NodeID:  1,
+		Role:    drbdadm.RoleSecondary,
+		Devices: []drbdadm.DevStatus{{DiskState: drbdadm.DiskUpToDate}},
+		Connections: []drbdadm.ConnStatus{
+			peer(0, "node1", drbdadm.RoleSecondary, drbdadm.DiskDiskless),
+			peer(2, "node3", drbdadm.RoleSecondary, drbdadm.DiskUpToDate),
+		},
+	}
+	if !verifier(status) {
+		t.Fatal("lowest node id with disk expected to verify")
+	}
+	if peers := verifyPeers(status); len(peers) != 1 || peers[0] != "node3" {
+		t.Fatalf("unexpected verify peers: %v", peers)
+	}
+
+	status.Connections[1] = peer(2, "node3", drbdadm.RolePrimary, drbdadm.DiskUpToDate)
+	if verifier(status) {
+		t.Fatal("primary peer expected to verify")
+	}
+
+	status.Connections[1] = peer(0, "node3", drbdadm.RoleSecondary, drbdadm.DiskUpToDate)
+	if verifier(status) {
+		t.Fatal("peer with lower node id expected to verify")
+	}
+
+	status.Role = drbdadm.RolePrimary
+	if !verifier(status) {
+		t.Fatal("primary expected to verify")
+	}
+}
@@ -0,0 +1,95 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package crd
+
+import (
+	"encoding/json"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+)
+
+// The custom resource has no generated client, any rest client of the cluster
+// such as clientset.CoreV1().RESTClient() reaches it by absolute path.
+
+func Create(client rest.Interface, r *DrbdResource) error {
+	r.APIVersion = Group + "/" + Version
+	r.Kind = Kind
+
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Post().AbsPath("/apis", Group, Version, Plural).Body(body).DoRaw()
+	return err
+}
+
+func Get(client rest.Interface, name string) (*DrbdResource, error) {
+	out, err := client.Get().AbsPath("/apis", Group, Version, Plural, name).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &DrbdResource{}
+	if err := json.Unmarshal(out, r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func List(client rest.Interface) ([]DrbdResource, error) {
+	out, err := client.Get().AbsPath("/apis", Group, Version, Plural).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	list := &DrbdResourceList{}
+	if err := json.Unmarshal(out, list); err != nil {
+		return nil, err
+	}
+
+	return list.Items, nil
+}
+
+// Update replaces r, it fails with a conflict if r changed since it was read
+func Update(client rest.Interface, r *DrbdResource) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Put().AbsPath("/apis", Group, Version, Plural, r.Name).Body(body).DoRaw()
+	return err
+}
+
+func Delete(client rest.Interface, name string) error {
+	_, err := client.Delete().AbsPath("/apis", Group, Version, Plural, name).DoRaw()
+	return err
+}
+
+// PatchStatus replaces the status entry of node, entries of other nodes are
+// left alone so that nodes never conflict.
+func PatchStatus(client rest.Interface, name, node string, status DrbdNodeStatus) error {
+	body, err := statusPatch(node, status)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Patch(types.MergePatchType).AbsPath("/apis", Group, Version, Plural, name).Body(body).DoRaw()
+	return err
+}
+
+func statusPatch(node string, status DrbdNodeStatus) ([]byte, error) {
+	patch := map[string]interface{}{
+		"status": map[string]interface{}{
+			"nodes": map[string]interface{}{
+				node: status,
+			},
+		},
+	}
+
+	return json.Marshal(patch)
+}
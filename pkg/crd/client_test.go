@@ -0,0 +1,33 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package crd
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStatusPatch(t *testing.T) {
+	status := DrbdNodeStatus{
+		Role:        "Primary",
+		DiskState:   "UpToDate",
+		Connections: map[string]string{"node2.example.com": "Connected"},
+	}
+
+	body, err := statusPatch("node1.example.com", status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &DrbdResource{}
+	if err := json.Unmarshal(body, r); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Status.Nodes) != 1 || r.Status.Nodes["node1.example.com"].Role != "Primary" {
+		t.Fatalf("unexpected patch: %s", body)
+	}
+	if r.Spec.Nodes != nil {
+		t.Fatalf("patch must not touch spec: %s", body)
+	}
+}
@@ -0,0 +1,86 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package crd
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	Group   = "drbd.ctriple.cn"
+	Version = "v1alpha1"
+	Kind    = "DrbdResource"
+	Plural  = "drbdresources"
+)
+
+// DrbdResource describes a drbd resource, it is named after the
+// PersistentVolume it backs. The spec is maintained by stor, and every node
+// agent maintains its own entry of the status.
+type DrbdResource struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DrbdResourceSpec   `json:"spec"`
+	Status DrbdResourceStatus `json:"status,omitempty"`
+}
+
+type DrbdResourceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []DrbdResource `json:"items"`
+}
+
+type DrbdResourceSpec struct {
+	// Backing disk size, such as 1025M
+	Size     string `json:"size"`
+	Port     int    `json:"port"`
+	Minor    int    `json:"minor"`
+	Replicas int    `json:"replicas"`
+
+	Nodes []DrbdNode `json:"nodes"`
+
+	// DRBD net, disk and resource options of the resource file
+	Options DrbdOptions `json:"options,omitempty"`
+}
+
+type DrbdNode struct {
+	Name string `json:"name"`
+	// Replication addresses, comma separated, one for each path
+	Address  string `json:"address"`
+	NodeID   int    `json:"nodeID"`
+	Diskless bool   `json:"diskless,omitempty"`
+}
+
+type DrbdOptions struct {
+	Net      map[string]string `json:"net,omitempty"`
+	Disk     map[string]string `json:"disk,omitempty"`
+	Resource map[string]string `json:"resource,omitempty"`
+}
+
+type DrbdResourceStatus struct {
+	// Keyed by node name
+	Nodes map[string]DrbdNodeStatus `json:"nodes,omitempty"`
+}
+
+// DrbdNodeStatus is the resource status as seen by one node
+type DrbdNodeStatus struct {
+	Role      string `json:"role"`
+	DiskState string `json:"diskState"`
+
+	// Connection state keyed by peer name
+	Connections map[string]string `json:"connections,omitempty"`
+
+	// Data known out of sync with any peer
+	OutOfSyncKiB uint64 `json:"outOfSyncKiB"`
+
+	// Connected to all peers, all UpToDate with nothing out of sync
+	InSync bool `json:"inSync"`
+
+	// When this node last got in sync
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// When this status last changed
+	UpdateTime metav1.Time `json:"updateTime"`
+}
@@ -0,0 +1,176 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package crypt
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// A resource encrypted below DRBD has its backing disk opened as mapping
+// {resource}_lower, which is the disk of the resource. Encrypted above DRBD,
+// the drbd device is opened as mapping {resource}_crypt on the Primary, which
+// is mounted.
+const (
+	belowSuffix = "_lower"
+	aboveSuffix = "_crypt"
+
+	mapperDir = "/dev/mapper"
+)
+
+// BelowName returns the mapping name of resource encrypted below DRBD
+func BelowName(resName string) string {
+	return resName + belowSuffix
+}
+
+// AboveName returns the mapping name of resource encrypted above DRBD
+func AboveName(resName string) string {
+	return resName + aboveSuffix
+}
+
+// Mapper returns the device of mapping name
+func Mapper(name string) string {
+	return path.Join(mapperDir, name)
+}
+
+// IsLuks returns true if device has a LUKS header. Only exit status 1 of
+// cryptsetup means it has none, any other failure is an error.
+func IsLuks(device string) (bool, error) {
+	out, err := exec.Command("cryptsetup", "isLuks", device).CombinedOutput()
+	if err == nil {
+		return true, nil
+	}
+	if exitStatus(err) == 1 {
+		return false, nil
+	}
+
+	log.Println("cryptsetup isLuks", device, string(out))
+	return false, err
+}
+
+// Signature returns the type of the filesystem, partition table or other
+// header blkid finds on device, empty if there is none.
+func Signature(device string) (string, error) {
+	out, err := exec.Command("blkid", "-p", "-o", "udev", device).CombinedOutput()
+	switch exitStatus(err) {
+	case 0:
+	case 2:
+		// Nothing found
+		return "", nil
+	case 4:
+		return "ambivalent", nil
+	default:
+		log.Println("blkid -p -o udev", device, string(out))
+		return "", err
+	}
+
+	for _, field := range strings.Fields(string(out)) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) == 2 && (kv[0] == "ID_FS_TYPE" || kv[0] == "ID_PART_TABLE_TYPE") {
+			return kv[1], nil
+		}
+	}
+	return "unknown", nil
+}
+
+// Format sets up LUKS2 on device with key. A device with a signature, such as
+// a filesystem, is refused, its data would be lost.
+func Format(device string, key []byte) error {
+	sig, err := Signature(device)
+	if err != nil {
+		return err
+	}
+	if sig != "" {
+		return fmt.Errorf("device: %s has a %s signature, not formatted", device, sig)
+	}
+
+	cmd := exec.Command("cryptsetup", "luksFormat", "--type", "luks2", "--batch-mode", "--key-file=-", device)
+	cmd.Stdin = bytes.NewReader(key)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Println("cryptsetup luksFormat --type luks2 --batch-mode --key-file=-", device, string(out))
+		return err
+	}
+
+	return nil
+}
+
+// Open opens the LUKS device as mapping name with key
+func Open(device, name string, key []byte) error {
+	cmd := exec.Command("cryptsetup", "open", "--type", "luks", "--key-file=-", device, name)
+	cmd.Stdin = bytes.NewReader(key)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Println("cryptsetup open --type luks --key-file=-", device, name, string(out))
+		return err
+	}
+
+	return nil
+}
+
+// Opened returns true if mapping name is active
+func Opened(name string) bool {
+	if _, err := exec.Command("cryptsetup", "status", name).CombinedOutput(); err != nil {
+		return false
+	}
+
+	return true
+}
+
+// Close closes mapping name, it is fine if it is not open
+func Close(name string) error {
+	if !Opened(name) {
+		return nil
+	}
+
+	if out, err := exec.Command("cryptsetup", "close", name).CombinedOutput(); err != nil {
+		log.Println("cryptsetup close", name, string(out))
+		return err
+	}
+
+	return nil
+}
+
+// ResByMntDir returns the resource whose mapping encrypted above DRBD is
+// mounted on mountDir
+func ResByMntDir(mountDir string) (string, error) {
+	out, err := exec.Command("findmnt", "-f", "-n", "--output", "SOURCE", mountDir).CombinedOutput()
+	if err != nil {
+		log.Println("findmnt -f -n --output SOURCE", mountDir, string(out))
+		return "", err
+	}
+
+	// NOTE: command output has superfluous whitespace
+	device := strings.TrimSpace(string(out))
+
+	return resByMapper(device)
+}
+
+func resByMapper(device string) (string, error) {
+	name := strings.TrimPrefix(device, mapperDir+"/")
+	if name == device || !strings.HasSuffix(name, aboveSuffix) || name == aboveSuffix {
+		return "", fmt.Errorf("device: %s is not encrypted above drbd.", device)
+	}
+
+	return strings.TrimSuffix(name, aboveSuffix), nil
+}
+
+// exitStatus returns the exit status of a command run with err, -1 if it did
+// not exit.
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
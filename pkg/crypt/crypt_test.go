@@ -0,0 +1,36 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package crypt
+
+import (
+	"fmt"
+	"os/exec"
+	"testing"
+)
+
+func TestResByMapper(t *testing.T) {
+	resName, err := resByMapper(Mapper(AboveName("default-pvc1")))
+	if err != nil || resName != "default-pvc1" {
+		t.Fatalf("unexpected resource: %q %v", resName, err)
+	}
+
+	for _, device := range []string{"/dev/drbd7", Mapper(BelowName("default-pvc1")), Mapper("_crypt"), "/dev/default-pvc1_crypt"} {
+		if _, err := resByMapper(device); err == nil {
+			t.Fatalf("%s is not encrypted above drbd", device)
+		}
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	for _, code := range []int{0, 1, 2, 4} {
+		err := exec.Command("sh", "-c", fmt.Sprintf("exit %d", code)).Run()
+		if status := exitStatus(err); status != code {
+			t.Fatalf("unexpected exit status of exit %d: %d", code, status)
+		}
+	}
+
+	if status := exitStatus(exec.Command("/nonexistent").Run()); status != -1 {
+		t.Fatalf("unexpected exit status of a command not run: %d", status)
+	}
+}
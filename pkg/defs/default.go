@@ -3,6 +3,10 @@
 //
 package defs
 
+import (
+	"time"
+)
+
 const (
 	Vendor = "ctriple.cn"
 	Driver = "drbd"
@@ -21,6 +25,27 @@ const (
 
 	// Lvm volume group from which drbd backing disk alloc
 	DrbdDiskVG = "centos"
+
+	// Lvm tag of drbd backing disks, only tagged disks are garbage collected
+	DrbdDiskTag = "ctriple_drbd"
+
+	// How long a new resource waits for all its peers to connect
+	DrbdConnectTimeout = 2 * time.Minute
+
+	// Host path of the flexvolume driver executable, it is also DRBD
+	// fence-peer handler
+	FlexDriverExec = "/usr/libexec/kubernetes/kubelet-plugins/volume/exec/" + Vendor + "~" + Driver + "/" + Driver
+
+	// Host dir of the node agent state shared with the DRBD handlers, only
+	// root may access it
+	AgentStateDir = "/var/lib/ctriple-drbd/agent"
+	// Node agent serves fence-peer handler on this unix socket
+	AgentFenceSocket = AgentStateDir + "/fence.sock"
+	// split-brain handler leaves {resource}/{peer} here for the agent
+	SplitBrainDir = AgentStateDir + "/split-brain"
+
+	// DRBD_KERNEL_VERSION_CODE of the first DRBD with tls transport
+	DrbdTLSVersionCode = 0x090200
 )
 
 type SyncJob string
@@ -28,6 +53,14 @@ type SyncJob string
 const (
 	SyncJob_New = "SYNCJOB_NEW"
 	SyncJob_Del = "SYNCJOB_DEL"
+
+	// Fail if the resource is in use on its host, run on all hosts before
+	// SyncJob_Del
+	SyncJob_Check = "SYNCJOB_CHECK"
+
+	// Skip initial sync of a new resource, run on one of its hosts after
+	// SyncJob_New completed on all hosts
+	SyncJob_ClearBitmap = "SYNCJOB_CLEAR_BITMAP"
 )
 
 const (
@@ -36,4 +69,156 @@ const (
 	SyncJob_EnvResSize = "SYNCJOB_RESOURCE_SIZE"
 	SyncJob_EnvResHost = "SYNCJOB_RESOURCE_HOST"
 	SyncJob_EnvResIP   = "SYNCJOB_RESOURCE_IP"
+	SyncJob_EnvResNet  = "SYNCJOB_RESOURCE_NET"
+	SyncJob_EnvResDisk = "SYNCJOB_RESOURCE_DISK"
+	SyncJob_EnvResOpts = "SYNCJOB_RESOURCE_OPTIONS"
+
+	// Hosts without backing disk, such as quorum tiebreakers
+	SyncJob_EnvResDiskless = "SYNCJOB_RESOURCE_DISKLESS"
+
+	// Host the sync job runs on, named as in SyncJob_EnvResHost
+	SyncJob_EnvNode = "SYNCJOB_NODE"
+
+	// Only set on the archive host when deleting a resource archived
+	SyncJob_EnvArchive          = "SYNCJOB_ARCHIVE"
+	SyncJob_EnvArchiveDir       = "SYNCJOB_ARCHIVE_DIR"
+	SyncJob_EnvArchiveRetention = "SYNCJOB_ARCHIVE_RETENTION"
+
+	// Only set when encrypted below DRBD, the key comes from the encryption
+	// Secret
+	SyncJob_EnvEncryption    = "SYNCJOB_ENCRYPTION"
+	SyncJob_EnvEncryptionKey = "SYNCJOB_ENCRYPTION_KEY"
+
+	// Peer authentication shared secret, comes from the peer Secret
+	SyncJob_EnvResSecret = "SYNCJOB_RESOURCE_SECRET"
+
+	// Only set on the seed host when cloning from an existing resource
+	SyncJob_EnvResSource   = "SYNCJOB_RESOURCE_SOURCE"
+	SyncJob_EnvResClone    = "SYNCJOB_RESOURCE_CLONE"
+	SyncJob_EnvResQuiesced = "SYNCJOB_RESOURCE_QUIESCED"
+)
+
+// How a cloned resource gets its data from the source resource
+const (
+	// Copy from a lvm snapshot of the source backing disk
+	Clone_Snapshot = "snapshot"
+	// Copy from the source backing disk directly
+	Clone_Copy = "copy"
+)
+
+// How a deleted resource keeps its data
+const (
+	// Write a zstd compressed image of the backing disk into the archive
+	// dir
+	Archive_Zstd = "zstd"
+	// Rename the backing disk in its volume group
+	Archive_Rename = "rename"
+
+	ArchiveDir       = "/var/lib/ctriple-drbd/archive"
+	ArchiveRetention = 7 * 24 * time.Hour
+)
+
+// Where a resource is encrypted with LUKS, the key is the EncryptionSecretKey
+// of a Secret in the provisioner namespace
+const (
+	// Backing disk on every host, DRBD replicates plaintext
+	Encryption_Below = "below"
+	// DRBD device on the Primary, DRBD replicates ciphertext
+	Encryption_Above = "above"
+
+	EncryptionSecretKey = "key"
+
+	// LUKS2 header, the backing disk of an encrypted resource is larger by
+	// this
+	EncryptionHeaderMB = 16
+)
+
+// Peers of a resource authenticate each other with a shared secret, it is the
+// PeerSecretKey of Secret PeerSecretPrefix+{pv} in the provisioner namespace
+const (
+	PeerSecretPrefix = "drbd-"
+	PeerSecretKey    = "shared-secret"
+	PeerAuthAlg      = "sha256"
+)
+
+const (
+	// Set on PersistentVolumes by their provisioner
+	AnnCreatedBy = "kubernetes.io/createdby"
+
+	// Annotation prefix of ctriple.cn/drbd owned kubernetes objects
+	AnnPrefix = Driver + "." + Vendor + "/"
+
+	// Set "true" on a PersistentVolumeClaim whose writer has been stopped,
+	// so that it can be cloned even if it is still Primary somewhere.
+	AnnQuiesced = AnnPrefix + "quiesced"
+
+	// Hosts of a PersistentVolume which have a diskless replica, they are
+	// not part of the PersistentVolume node affinity.
+	AnnDiskless = AnnPrefix + "diskless"
+
+	// Archive method, dir and retention of a PersistentVolume, see
+	// Archive_Zstd and Archive_Rename
+	AnnArchive          = AnnPrefix + "archive"
+	AnnArchiveDir       = AnnPrefix + "archive-dir"
+	AnnArchiveRetention = AnnPrefix + "archive-retention"
+
+	// Encryption of a PersistentVolume and its Secret namespace/name, see
+	// Encryption_Below and Encryption_Above
+	AnnEncryption       = AnnPrefix + "encryption"
+	AnnEncryptionSecret = AnnPrefix + "encryption-secret"
+
+	// Provisioning progress of a PersistentVolumeClaim
+	AnnJournal = AnnPrefix + "provision-journal"
+
+	// Set on a PersistentVolume in split brain to the node whose data
+	// survives, data on all other nodes is discarded.
+	AnnSplitBrainSurvivor = AnnPrefix + "split-brain-survivor"
+
+	// Set on a PersistentVolume to ask a node agent for an operation on
+	// the resource, the agent replaces it with the result annotation when
+	// done. See agent.Operation.
+	AnnOperation       = AnnPrefix + "operation"
+	AnnOperationResult = AnnPrefix + "operation-result"
+
+	// Progress of the nodes of a PersistentVolume rejoining after their
+	// backing disk was lost, see agent rejoin
+	AnnRejoin = AnnPrefix + "rejoin"
+
+	// When online verify of a PersistentVolume last finished, RFC3339
+	AnnLastVerify = AnnPrefix + "last-verify"
+
+	// Replication addresses of a Node set by hand, comma separated, one for
+	// each DRBD path. It takes precedence over the replication network of
+	// the provisioner.
+	AnnReplicationAddress = AnnPrefix + "replication-address"
+
+	// Interface addresses of a Node published by its agent, comma separated
+	// {interface}={ip}
+	AnnAddresses = AnnPrefix + "addresses"
+
+	// Set on a sync job to {resource}/{host}/{step}
+	AnnSyncJob = AnnPrefix + "sync-job"
+
+	// Set on a fenced Node to the resources which fenced it
+	AnnFenced = AnnPrefix + "fenced"
+
+	// Taint of a fenced Node, evicts the pods which may still use a
+	// resource there
+	TaintFenced = AnnPrefix + "fenced"
+
+	// Taint the admin puts on a Node after powering it off, it confirms the
+	// Node is down
+	TaintOutOfService = "node.kubernetes.io/out-of-service"
+)
+
+// How the node agent fenced a peer, see DRBD fence-peer handler
+const (
+	// This node has quorum, the peer can not write without
+	Fence_Outdated = "outdated"
+	// The peer is confirmed down
+	Fence_Fenced = "fenced"
+	// The peer is Ready and may be Primary
+	Fence_Refused = "refused"
+	// The peer is NotReady, but may still be running
+	Fence_Unconfirmed = "unconfirmed"
 )
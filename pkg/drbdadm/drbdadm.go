@@ -7,12 +7,14 @@ import (
 	"fmt"
 	"log"
 	"os/exec"
+	"strconv"
 	"strings"
 )
 
-// Primary promote this drbd node as primary role
+// Primary promote this drbd node as primary role, DRBD refuses if local data
+// is not UpToDate or a disconnected peer could not be fenced.
 func Primary(resName string) error {
-	out, err := exec.Command("drbdadm", "primary", resName, "--force").CombinedOutput()
+	out, err := exec.Command("drbdadm", "primary", resName).CombinedOutput()
 	if err != nil {
 		log.Println("drbdadm primary", resName, string(out))
 		return err
@@ -21,6 +23,18 @@ func Primary(resName string) error {
 	return nil
 }
 
+// ForcePrimary promote this drbd node as primary role even if local data is
+// not UpToDate, which makes local data the UpToDate one.
+func ForcePrimary(resName string) error {
+	out, err := exec.Command("drbdadm", "primary", resName, "--force").CombinedOutput()
+	if err != nil {
+		log.Println("drbdadm primary", resName, "--force", string(out))
+		return err
+	}
+
+	return nil
+}
+
 // Secondary demote this drbd node as secondary role
 func Secondary(resName string) error {
 	out, err := exec.Command("drbdadm", "secondary", resName).CombinedOutput()
@@ -43,6 +57,29 @@ func CreateMD(resName string) error {
 	return nil
 }
 
+// HasMD returns true if the backing disk of this resource already has valid
+// metadata, the resource must be down.
+func HasMD(resName string) bool {
+	if _, err := exec.Command("drbdadm", "dump-md", resName).CombinedOutput(); err != nil {
+		return false
+	}
+
+	return true
+}
+
+// NewCurrentUUID starts a new data generation of this resource and clears the
+// sync bitmap, all connected peers consider their data identical to ours. It
+// is only meant to skip the initial sync of a newly created resource.
+func NewCurrentUUID(resName string) error {
+	out, err := exec.Command("drbdadm", "new-current-uuid", "--clear-bitmap", resName).CombinedOutput()
+	if err != nil {
+		log.Println("drbdadm new-current-uuid --clear-bitmap", resName, string(out))
+		return err
+	}
+
+	return nil
+}
+
 // Up makes this resource on the current drbd node start serving
 func Up(resName string) error {
 	out, err := exec.Command("drbdadm", "up", resName).CombinedOutput()
@@ -54,6 +91,29 @@ func Up(resName string) error {
 	return nil
 }
 
+// UpDiskless brings this resource up without its backing disk, so that it
+// connects to its peers diskless
+func UpDiskless(resName string) error {
+	out, err := exec.Command("drbdadm", "adjust", "--skip-disk", resName).CombinedOutput()
+	if err != nil {
+		log.Println("drbdadm adjust --skip-disk", resName, string(out))
+		return err
+	}
+
+	return nil
+}
+
+// Attach attaches the backing disk of this resource, which is up diskless
+func Attach(resName string) error {
+	out, err := exec.Command("drbdadm", "attach", resName).CombinedOutput()
+	if err != nil {
+		log.Println("drbdadm attach", resName, string(out))
+		return err
+	}
+
+	return nil
+}
+
 // Down makes this resource on the current drbd node stop serving
 func Down(resName string) error {
 	out, err := exec.Command("drbdadm", "down", resName).CombinedOutput()
@@ -76,6 +136,143 @@ func Adjust(resName string) error {
 	return nil
 }
 
+// AdjustDryRun returns the drbdsetup commands Adjust would run, none if the
+// running resource as shown by drbdsetup show matches its resource file.
+func AdjustDryRun(resName string) ([]string, error) {
+	out, err := exec.Command("drbdadm", "--dry-run", "adjust", resName).CombinedOutput()
+	if err != nil {
+		log.Println("drbdadm --dry-run adjust", resName, string(out))
+		return nil, err
+	}
+
+	var cmds []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			cmds = append(cmds, line)
+		}
+	}
+
+	return cmds, nil
+}
+
+// Connect connects this node to peer node of the resource, or to all peers if
+// peer is empty. discardMyData makes this node the split brain victim which
+// resyncs all changes from peer.
+func Connect(resName, peer string, discardMyData bool) error {
+	args := []string{"connect", target(resName, peer)}
+	if discardMyData {
+		args = append(args, "--discard-my-data")
+	}
+
+	out, err := exec.Command("drbdadm", args...).CombinedOutput()
+	if err != nil {
+		log.Println("drbdadm", strings.Join(args, " "), string(out))
+		return err
+	}
+
+	return nil
+}
+
+// Disconnect disconnects this node from peer node of the resource, or from all
+// peers if peer is empty.
+func Disconnect(resName, peer string) error {
+	out, err := exec.Command("drbdadm", "disconnect", target(resName, peer)).CombinedOutput()
+	if err != nil {
+		log.Println("drbdadm disconnect", target(resName, peer), string(out))
+		return err
+	}
+
+	return nil
+}
+
+// Invalidate discards local data of the resource, it is fully resynced from an
+// UpToDate peer.
+func Invalidate(resName string) error {
+	out, err := exec.Command("drbdadm", "invalidate", resName).CombinedOutput()
+	if err != nil {
+		log.Println("drbdadm invalidate", resName, string(out))
+		return err
+	}
+
+	return nil
+}
+
+// Verify starts online verify of the resource against peer, or all peers if
+// peer is empty. Blocks found different are marked out of sync, they are not
+// resynced until reconnected.
+func Verify(resName, peer string) error {
+	out, err := exec.Command("drbdadm", "verify", target(resName, peer)).CombinedOutput()
+	if err != nil {
+		log.Println("drbdadm verify", target(resName, peer), string(out))
+		return err
+	}
+
+	return nil
+}
+
+// ResyncRate limits resync and online verify of the resource to rate per
+// second, such as 20M, until the resource is adjusted.
+func ResyncRate(resName, rate string) error {
+	args := []string{"peer-device-options", "--c-max-rate=" + rate, "--resync-rate=" + rate, resName}
+
+	out, err := exec.Command("drbdadm", args...).CombinedOutput()
+	if err != nil {
+		log.Println("drbdadm", strings.Join(args, " "), string(out))
+		return err
+	}
+
+	return nil
+}
+
+// target returns the drbdadm connection of resource to peer, or the resource
+// itself if peer is empty.
+func target(resName, peer string) string {
+	if peer == "" {
+		return resName
+	}
+	return resName + ":" + peer
+}
+
+// Dump parses resource of config file the way drbdadm does, it fails if the
+// file is not valid.
+func Dump(file, resName string) error {
+	out, err := exec.Command("drbdadm", "-c", file, "dump", resName).CombinedOutput()
+	if err != nil {
+		log.Println("drbdadm -c", file, "dump", resName, string(out))
+		return fmt.Errorf("%s: %s", file, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// KernelVersion returns DRBD_KERNEL_VERSION_CODE of the loaded DRBD module,
+// such as 0x090202 for 9.2.2
+func KernelVersion() (int, error) {
+	out, err := exec.Command("drbdadm", "--version").CombinedOutput()
+	if err != nil {
+		log.Println("drbdadm --version", string(out))
+		return 0, err
+	}
+
+	return kernelVersion(string(out))
+}
+
+func kernelVersion(out string) (int, error) {
+	for _, line := range strings.Split(out, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) < 2 || kv[0] != "DRBD_KERNEL_VERSION_CODE" {
+			continue
+		}
+		code, err := strconv.ParseInt(kv[1], 0, 32)
+		if err != nil {
+			return 0, err
+		}
+		return int(code), nil
+	}
+
+	return 0, fmt.Errorf("DRBD kernel module not loaded")
+}
+
 // ShResources returns all resource names on this drbd node
 func ShResources() ([]string, error) {
 	out, err := exec.Command("drbdadm", "sh-resources").CombinedOutput()
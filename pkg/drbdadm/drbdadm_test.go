@@ -0,0 +1,29 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package drbdadm
+
+import (
+	"testing"
+)
+
+func TestKernelVersion(t *testing.T) {
+	out := `DRBDADM_BUILDTAG=GIT-hash:\ 409097fe02187f83790b88ac3e0d94f3c167adab\ build\ by\ buildd@lcy02-amd64-080
+DRBDADM_API_VERSION=2
+DRBD_KERNEL_VERSION_CODE=0x090202
+DRBD_KERNEL_VERSION=9.2.2
+DRBDADM_VERSION_CODE=0x091700
+DRBDADM_VERSION=9.23.0
+`
+	code, err := kernelVersion(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 0x090202 {
+		t.Fatalf("unexpected version code: %#x", code)
+	}
+
+	if _, err := kernelVersion("DRBDADM_API_VERSION=2\n"); err == nil {
+		t.Fatal("expect error without kernel module")
+	}
+}
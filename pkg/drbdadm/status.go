@@ -0,0 +1,253 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package drbdadm
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+const (
+	RolePrimary   = "Primary"
+	RoleSecondary = "Secondary"
+
+	ConnConnected  = "Connected"
+	ConnStandAlone = "StandAlone"
+
+	DiskUpToDate     = "UpToDate"
+	DiskInconsistent = "Inconsistent"
+	DiskDiskless     = "Diskless"
+
+	// Online verify source and target
+	ReplVerifyS = "VerifyS"
+	ReplVerifyT = "VerifyT"
+
+	// Resync from the peer
+	ReplSyncTarget = "SyncTarget"
+)
+
+// ResStatus is the drbd resource runtime status on this node, as reported by
+// `drbdsetup status --json --statistics`. Sizes are in KiB.
+type ResStatus struct {
+	Name        string       `json:"name"`
+	NodeID      int          `json:"node-id"`
+	Role        string       `json:"role"`
+	Devices     []DevStatus  `json:"devices"`
+	Connections []ConnStatus `json:"connections"`
+}
+
+// DevStatus is the local volume status of a drbd resource
+type DevStatus struct {
+	Volume    int    `json:"volume"`
+	Minor     int    `json:"minor"`
+	DiskState string `json:"disk-state"`
+	Quorum    bool   `json:"quorum"`
+	Size      uint64 `json:"size"`
+
+	Read         uint64 `json:"read"`
+	Written      uint64 `json:"written"`
+	ALWrites     uint64 `json:"al-writes"`
+	BMWrites     uint64 `json:"bm-writes"`
+	UpperPending uint64 `json:"upper-pending"`
+	LowerPending uint64 `json:"lower-pending"`
+	ALSuspended  bool   `json:"al-suspended"`
+}
+
+// ConnStatus is the status of the connection to one peer node
+type ConnStatus struct {
+	PeerNodeID      int             `json:"peer-node-id"`
+	Name            string          `json:"name"`
+	ConnectionState string          `json:"connection-state"`
+	PeerRole        string          `json:"peer-role"`
+	PeerDevices     []PeerDevStatus `json:"peer_devices"`
+}
+
+// PeerDevStatus is the status of a volume as seen on one peer node
+type PeerDevStatus struct {
+	Volume           int    `json:"volume"`
+	ReplicationState string `json:"replication-state"`
+	PeerDiskState    string `json:"peer-disk-state"`
+
+	Received  uint64 `json:"received"`
+	Sent      uint64 `json:"sent"`
+	OutOfSync uint64 `json:"out-of-sync"`
+	Pending   uint64 `json:"pending"`
+	Unacked   uint64 `json:"unacked"`
+
+	// Only reported while resync or online verify is running
+	HasSyncDetails bool    `json:"has-sync-details"`
+	PercentInSync  float64 `json:"percent-in-sync"`
+	RsDt1Ms        uint64  `json:"rs-dt1-ms"`
+	RsDb1Sectors   uint64  `json:"rs-db1-sectors"`
+}
+
+// ResyncRate returns the recent resync rate in KiB/s
+func (pd PeerDevStatus) ResyncRate() float64 {
+	if !pd.HasSyncDetails || pd.RsDt1Ms == 0 {
+		return 0
+	}
+
+	// 2 sectors per KiB
+	return float64(pd.RsDb1Sectors) / 2 / (float64(pd.RsDt1Ms) / 1000)
+}
+
+// Status returns the runtime status of a resource which is up on this node
+func Status(resName string) (ResStatus, error) {
+	out, err := exec.Command("drbdsetup", "status", resName, "--json", "--statistics").CombinedOutput()
+	if err != nil {
+		log.Println("drbdsetup status", resName, "--json --statistics", string(out))
+		return ResStatus{}, err
+	}
+
+	return parseStatus(resName, out)
+}
+
+func parseStatus(resName string, out []byte) (ResStatus, error) {
+	var status []ResStatus
+	if err := json.Unmarshal(out, &status); err != nil {
+		return ResStatus{}, err
+	}
+
+	for _, s := range status {
+		if s.Name == resName {
+			return s, nil
+		}
+	}
+
+	return ResStatus{}, fmt.Errorf("resource: %s has no status.", resName)
+}
+
+// Primaries returns the nodes which have this resource in Primary role, the
+// local node is reported by name "" (empty string).
+func (s ResStatus) Primaries() []string {
+	var nodes []string
+
+	if s.Role == RolePrimary {
+		nodes = append(nodes, "")
+	}
+	for _, c := range s.Connections {
+		if c.PeerRole == RolePrimary {
+			nodes = append(nodes, c.Name)
+		}
+	}
+
+	return nodes
+}
+
+// Fresh returns true if no replica of the resource has UpToDate data, which
+// is the case for resources created before the initial sync was skipped.
+func (s ResStatus) Fresh() bool {
+	for _, d := range s.Devices {
+		if d.DiskState != DiskInconsistent {
+			return false
+		}
+	}
+	for _, c := range s.Connections {
+		if c.ConnectionState != ConnConnected {
+			return false
+		}
+		for _, pd := range c.PeerDevices {
+			if pd.PeerDiskState == DiskUpToDate {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// SyncTarget returns how much of the data is in sync while this node resyncs
+// from a peer, ok is false if no resync is running.
+func (s ResStatus) SyncTarget() (percent float64, ok bool) {
+	for _, c := range s.Connections {
+		for _, pd := range c.PeerDevices {
+			if pd.ReplicationState == ReplSyncTarget && (!ok || pd.PercentInSync < percent) {
+				percent, ok = pd.PercentInSync, true
+			}
+		}
+	}
+
+	return percent, ok
+}
+
+// Quorate returns true if all volumes of this node have quorum, which is
+// always the case if quorum is off.
+func (s ResStatus) Quorate() bool {
+	for _, d := range s.Devices {
+		if !d.Quorum {
+			return false
+		}
+	}
+
+	return len(s.Devices) > 0
+}
+
+// Connected returns true if this node is connected to all its peers, false
+// if it has none yet.
+func (s ResStatus) Connected() bool {
+	if len(s.Connections) == 0 {
+		return false
+	}
+	for _, c := range s.Connections {
+		if c.ConnectionState != ConnConnected {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Verifying returns true if online verify is running with any peer
+func (s ResStatus) Verifying() bool {
+	for _, c := range s.Connections {
+		for _, pd := range c.PeerDevices {
+			if pd.ReplicationState == ReplVerifyS || pd.ReplicationState == ReplVerifyT {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// InUse returns true if a resource which is up on this node is Primary, or
+// any of its devices is opened, such as mounted.
+func InUse(resName string) (bool, error) {
+	status, err := Status(resName)
+	if err != nil {
+		return false, err
+	}
+	if status.Role == RolePrimary {
+		return true, nil
+	}
+
+	out, err := exec.Command("drbdsetup", "events2", "--now", resName).CombinedOutput()
+	if err != nil {
+		log.Println("drbdsetup events2 --now", resName, string(out))
+		return false, err
+	}
+
+	return opened(string(out)), nil
+}
+
+// opened returns true if any device is opened in the `drbdsetup events2`
+// output.
+func opened(events string) bool {
+	for _, line := range strings.Split(events, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[1] != "device" {
+			continue
+		}
+		for _, f := range fields[2:] {
+			if f == "open:yes" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,157 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package drbdadm
+
+import (
+	"fmt"
+	"testing"
+)
+
+const statusJson = `[
+{
+  "name": "ns-pvc",
+  "node-id": 0,
+  "role": "Secondary",
+  "suspended": false,
+  "write-ordering": "flush",
+  "devices": [
+    {
+      "volume": 0,
+      "minor": 7,
+      "disk-state": "UpToDate",
+      "client": false,
+      "quorum": true,
+      "size": 1048508,
+      "read": 2052,
+      "written": 12,
+      "al-writes": 3,
+      "bm-writes": 0,
+      "upper-pending": 0,
+      "lower-pending": 1,
+      "al-suspended": false
+    } ],
+  "connections": [
+    {
+      "peer-node-id": 1,
+      "name": "node2.example.com",
+      "connection-state": "Connected",
+      "congested": false,
+      "peer-role": "Primary",
+      "peer_devices": [
+        {
+          "volume": 0,
+          "replication-state": "Established",
+          "peer-disk-state": "UpToDate",
+          "peer-client": false,
+          "resync-suspended": "no",
+          "received": 0,
+          "sent": 12,
+          "out-of-sync": 1024,
+          "pending": 0,
+          "unacked": 0,
+          "has-sync-details": true,
+          "has-online-verify-details": false,
+          "percent-in-sync": 99.90,
+          "rs-total": 1048508,
+          "rs-dt-start-ms": 3000,
+          "rs-paused-ms": 0,
+          "rs-dt0-ms": 3000,
+          "rs-db0-sectors": 6144,
+          "rs-dt1-ms": 2000,
+          "rs-db1-sectors": 4096
+        } ]
+    } ]
+}
+]
+`
+
+func TestOpened(t *testing.T) {
+	events := `exists resource name:ns-pvc role:Secondary suspended:no
+exists connection name:ns-pvc peer-node-id:1 conn-name:node2.example.com connection:Connected role:Primary
+exists device name:ns-pvc volume:0 minor:7 disk:UpToDate client:no quorum:yes open:%s
+exists peer-device name:ns-pvc peer-node-id:1 conn-name:node2.example.com volume:0 replication:Established peer-disk:UpToDate
+exists -
+`
+	if opened(fmt.Sprintf(events, "no")) {
+		t.Fatal("expect device not opened")
+	}
+	if !opened(fmt.Sprintf(events, "yes")) {
+		t.Fatal("expect device opened")
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	s, err := parseStatus("ns-pvc", []byte(statusJson))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Role != RoleSecondary || s.Devices[0].Minor != 7 || s.Devices[0].DiskState != "UpToDate" {
+		t.Fatalf("unexpected local status: %+v", s)
+	}
+	if c := s.Connections[0]; c.Name != "node2.example.com" || c.PeerDevices[0].PeerDiskState != "UpToDate" {
+		t.Fatalf("unexpected peer status: %+v", c)
+	}
+
+	if d := s.Devices[0]; d.ALWrites != 3 || d.LowerPending != 1 {
+		t.Fatalf("unexpected device statistics: %+v", d)
+	}
+	if pd := s.Connections[0].PeerDevices[0]; pd.OutOfSync != 1024 || pd.Sent != 12 || pd.ResyncRate() != 1024 {
+		t.Fatalf("unexpected peer device statistics: %+v rate: %v", pd, pd.ResyncRate())
+	}
+
+	primaries := s.Primaries()
+	if len(primaries) != 1 || primaries[0] != "node2.example.com" {
+		t.Fatalf("unexpected primaries: %v", primaries)
+	}
+
+	if _, err := parseStatus("no-such-res", []byte(statusJson)); err == nil {
+		t.Fatal("expect error for missing resource")
+	}
+}
+
+func TestSyncTarget(t *testing.T) {
+	s, err := parseStatus("ns-pvc", []byte(statusJson))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.SyncTarget(); ok {
+		t.Fatal("expect no resync")
+	}
+
+	s.Connections[0].PeerDevices[0].ReplicationState = ReplSyncTarget
+	if percent, ok := s.SyncTarget(); !ok || percent != 99.90 {
+		t.Fatalf("unexpected resync progress: %v %v", percent, ok)
+	}
+}
+
+func TestConnected(t *testing.T) {
+	s, err := parseStatus("ns-pvc", []byte(statusJson))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Connected() {
+		t.Fatal("expect connected")
+	}
+
+	s.Connections = nil
+	if s.Connected() {
+		t.Fatal("expect not connected without peers")
+	}
+}
+
+func TestQuorate(t *testing.T) {
+	s, err := parseStatus("ns-pvc", []byte(statusJson))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Quorate() {
+		t.Fatal("expect quorum")
+	}
+
+	s.Devices[0].Quorum = false
+	if s.Quorate() {
+		t.Fatal("expect no quorum")
+	}
+}
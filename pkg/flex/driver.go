@@ -4,9 +4,12 @@
 package flex
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 
+	"github.com/ctriple/drbd/pkg/crypt"
+	"github.com/ctriple/drbd/pkg/defs"
 	"github.com/ctriple/drbd/pkg/drbdadm"
 	"github.com/ctriple/drbd/pkg/flex/fs"
 )
@@ -31,6 +34,12 @@ func DriverRun(action string, args []string) exitCode {
 		return doMount(args)
 	case "unmount":
 		return doUnmount(args)
+	case "fence-peer":
+		return doFencePeer(args)
+	case "unfence-peer":
+		return doUnfencePeer(args)
+	case "split-brain":
+		return doSplitBrain(args)
 	default:
 		stdoutJson(errorNotSupported)
 		return ExitFailure
@@ -180,7 +189,7 @@ func doMount(args []string) exitCode {
 	}
 
 	// First: promote drbd resource as primary role
-	if err := drbdadm.Primary(opts.ResName); err != nil {
+	if err := promote(opts.ResName); err != nil {
 		echo := callEcho{
 			Status:  StatusFailure,
 			Message: fmt.Sprintf("%s", err),
@@ -201,9 +210,25 @@ func doMount(args []string) exitCode {
 		return ExitFailure
 	}
 
+	// Encrypted above drbd, the filesystem is on the mapping
+	if opts.Encryption == defs.Encryption_Above {
+		mapper, err := openAbove(opts, device)
+		if err != nil {
+			derr := drbdadm.Secondary(opts.ResName)
+
+			echo := callEcho{
+				Status:  StatusFailure,
+				Message: fmt.Sprintf("%s, %s", err, derr),
+			}
+			stdoutJson(echo)
+			return ExitFailure
+		}
+		device = mapper
+	}
+
 	// Second: format and mount device
 	if err := fs.Format(device, opts.FsType); err != nil {
-		derr := drbdadm.Secondary(opts.ResName)
+		derr := closeAbove(opts.ResName)
 
 		echo := callEcho{
 			Status:  StatusFailure,
@@ -213,7 +238,7 @@ func doMount(args []string) exitCode {
 		return ExitFailure
 	}
 	if err := fs.Mount(device, mountDir); err != nil {
-		derr := drbdadm.Secondary(opts.ResName)
+		derr := closeAbove(opts.ResName)
 
 		echo := callEcho{
 			Status:  StatusFailure,
@@ -248,6 +273,11 @@ func doUnmount(args []string) exitCode {
 	mountDir := args[1]
 
 	resName, err := drbdadm.ResByMntDir(mountDir)
+	encrypted := false
+	if err != nil {
+		resName, err = crypt.ResByMntDir(mountDir)
+		encrypted = err == nil
+	}
 	if err != nil {
 		echo := callEcho{
 			Status:  StatusFailure,
@@ -268,7 +298,12 @@ func doUnmount(args []string) exitCode {
 	}
 
 	// Second: demote drbd resource as role secondary
-	if err := drbdadm.Secondary(resName); err != nil {
+	if encrypted {
+		err = closeAbove(resName)
+	} else {
+		err = drbdadm.Secondary(resName)
+	}
+	if err != nil {
 		echo := callEcho{
 			Status:  StatusFailure,
 			Message: fmt.Sprintf("%s", err),
@@ -286,6 +321,66 @@ func doUnmount(args []string) exitCode {
 	return ExitSuccess
 }
 
+// promote promotes resource on this node. It refuses while another node is
+// still Primary, a disconnected peer which might be Primary is fenced by DRBD
+// fence-peer handler before promotion.
+func promote(resName string) error {
+	status, err := drbdadm.Status(resName)
+	if err != nil {
+		return err
+	}
+	for _, c := range status.Connections {
+		if c.PeerRole == drbdadm.RolePrimary {
+			return fmt.Errorf("resource: %s is still Primary on %s", resName, c.Name)
+		}
+	}
+
+	// Never synced resource, no replica has data worth keeping
+	if status.Fresh() {
+		return drbdadm.ForcePrimary(resName)
+	}
+
+	return drbdadm.Primary(resName)
+}
+
+// openAbove opens drbd device encrypted above DRBD, it is set up with LUKS
+// on first use. It returns the mapping device.
+func openAbove(opts Options, device string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(opts.Key)
+	if err != nil || len(key) == 0 {
+		return "", fmt.Errorf("resource: %s encryption key missing", opts.ResName)
+	}
+
+	name := crypt.AboveName(opts.ResName)
+	if crypt.Opened(name) {
+		return crypt.Mapper(name), nil
+	}
+	luks, err := crypt.IsLuks(device)
+	if err != nil {
+		return "", err
+	}
+	if !luks {
+		if err := crypt.Format(device, key); err != nil {
+			return "", err
+		}
+	}
+	if err := crypt.Open(device, name, key); err != nil {
+		return "", err
+	}
+
+	return crypt.Mapper(name), nil
+}
+
+// closeAbove closes the mapping of resource encrypted above DRBD if any, and
+// demotes resource.
+func closeAbove(resName string) error {
+	if err := crypt.Close(crypt.AboveName(resName)); err != nil {
+		return err
+	}
+
+	return drbdadm.Secondary(resName)
+}
+
 // stdoutJson will do json marshal val, and print the json string to standard
 // output. if val marshaled failed, it will print out an empty json object
 // string.
@@ -0,0 +1,111 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package flex
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ctriple/drbd/pkg/defs"
+)
+
+// DRBD waits for the fence-peer handler with io frozen
+const fenceTimeout = 30 * time.Second
+
+// Fence peer:
+//
+// Not a FlexVolume call-out, called by DRBD as fence-peer handler when this
+// node is (going to be) Primary and lost connection to a peer whose data may
+// still change. The node agent decides that since it has access to
+// kubernetes. Promotion goes on only if the peer can not write: this node has
+// quorum, or the peer is confirmed down. Otherwise it is refused.
+//
+// <driver executable> fence-peer
+//
+func doFencePeer(args []string) exitCode {
+	result, err := agentFence("/fence")
+	if err != nil {
+		log.Println("fence-peer", err)
+	}
+
+	return fenceExitCode(result, err)
+}
+
+// fenceExitCode returns what DRBD should do with the peer fenced as result.
+// Only proof that the peer can not write lets promotion go on, anything else,
+// such as an agent not reached, refuses it like a peer which is Primary.
+func fenceExitCode(result string, err error) exitCode {
+	if err != nil {
+		return ExitPeerPrimary
+	}
+
+	switch result {
+	case defs.Fence_Outdated:
+		return ExitPeerOutdated
+	case defs.Fence_Fenced:
+		return ExitPeerFenced
+	default:
+		return ExitPeerPrimary
+	}
+}
+
+// Unfence peer:
+//
+// Not a FlexVolume call-out, called by DRBD as unfence-peer handler when a
+// fenced peer is back in sync.
+//
+// <driver executable> unfence-peer
+//
+func doUnfencePeer(args []string) exitCode {
+	if _, err := agentFence("/unfence"); err != nil {
+		log.Println("unfence-peer", err)
+		return ExitFailure
+	}
+
+	return ExitSuccess
+}
+
+// agentFence asks the node agent to (un)fence the peer and returns how, DRBD
+// passes resource and peer to the handler in environment variables. The agent
+// listens on a unix socket only root may connect to.
+func agentFence(path string) (string, error) {
+	var (
+		resName = os.Getenv("DRBD_RESOURCE")
+		peer    = os.Getenv("DRBD_NODE_ID_" + os.Getenv("DRBD_PEER_NODE_ID"))
+	)
+
+	client := &http.Client{
+		Timeout: fenceTimeout,
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.Dial("unix", defs.AgentFenceSocket)
+			},
+		},
+	}
+	resp, err := client.PostForm("http://agent"+path, url.Values{
+		"resource": {resName},
+		"peer":     {peer},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s: %s %s", resName, peer, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return string(body), nil
+}
@@ -0,0 +1,32 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package flex
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ctriple/drbd/pkg/defs"
+)
+
+func TestFenceExitCode(t *testing.T) {
+	for _, c := range []struct {
+		result string
+		err    error
+		expect exitCode
+	}{
+		{defs.Fence_Outdated, nil, ExitPeerOutdated},
+		{defs.Fence_Fenced, nil, ExitPeerFenced},
+		{defs.Fence_Refused, nil, ExitPeerPrimary},
+		{defs.Fence_Unconfirmed, nil, ExitPeerPrimary},
+		{"", nil, ExitPeerPrimary},
+		{"unknown", nil, ExitPeerPrimary},
+		{"", fmt.Errorf("dial unix: connection refused"), ExitPeerPrimary},
+		{defs.Fence_Fenced, fmt.Errorf("timeout"), ExitPeerPrimary},
+	} {
+		if code := fenceExitCode(c.result, c.err); code != c.expect {
+			t.Fatalf("result:%q err:%v unexpected exit code: %d", c.result, c.err, code)
+		}
+	}
+}
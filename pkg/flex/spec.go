@@ -14,6 +14,13 @@ const (
 	ExitFailure
 )
 
+// DRBD fence-peer handler exit codes
+const (
+	ExitPeerOutdated exitCode = 4
+	ExitPeerPrimary  exitCode = 6
+	ExitPeerFenced   exitCode = 7
+)
+
 type exitStatus string
 
 const (
@@ -51,6 +58,11 @@ var errorNotSupported = callEcho{
 type Options struct {
 	FsType  string `json:"kubernetes.io/fsType"`
 	ResName string `json:"resource"`
+
+	// Set if encrypted above DRBD, the key is passed base64 encoded by
+	// kubelet from the pv SecretRef
+	Encryption string `json:"encryption"`
+	Key        string `json:"kubernetes.io/secret/key"`
 }
 
 func parseOptions(rawOpts string) (Options, error) {
@@ -0,0 +1,46 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package flex
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"github.com/ctriple/drbd/pkg/defs"
+)
+
+// Split brain:
+//
+// Not a FlexVolume call-out, called by DRBD as split-brain handler when it
+// detected a split brain with a peer which it could not resolve automatically.
+// The peer is left in the split brain dir, the node agent resolves it once a
+// survivor is chosen.
+//
+// <driver executable> split-brain
+//
+func doSplitBrain(args []string) exitCode {
+	var (
+		resName = os.Getenv("DRBD_RESOURCE")
+		peer    = os.Getenv("DRBD_NODE_ID_" + os.Getenv("DRBD_PEER_NODE_ID"))
+	)
+	if resName == "" || peer == "" {
+		log.Println("split-brain: DRBD_RESOURCE and peer node not set")
+		return ExitFailure
+	}
+
+	dir := path.Join(defs.SplitBrainDir, resName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Println("split-brain", resName, peer, err)
+		return ExitFailure
+	}
+	if err := ioutil.WriteFile(path.Join(dir, peer), []byte(time.Now().Format(time.RFC3339)), 0600); err != nil {
+		log.Println("split-brain", resName, peer, err)
+		return ExitFailure
+	}
+
+	return ExitSuccess
+}
@@ -4,9 +4,12 @@
 package stor
 
 import (
+	"fmt"
 	"sort"
 
 	"github.com/ctriple/drbd/pkg/defs"
+	"github.com/ctriple/drbd/pkg/sync/res"
+	"github.com/golang/glog"
 
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -39,6 +42,28 @@ func (p *flexProvisioner) candidates() (hosts, ips []string, err error) {
 	return
 }
 
+// seedFirst moves the best-fit candidate which already has a replica of the
+// clone source to the first position, other candidates keep their order.
+func seedFirst(hosts, ips, srcHosts []string) error {
+	for i, h := range hosts {
+		for _, s := range srcHosts {
+			if h != s {
+				continue
+			}
+			copy(hosts[1:i+1], hosts[:i])
+			hosts[0] = h
+			ip := ips[i]
+			copy(ips[1:i+1], ips[:i])
+			ips[0] = ip
+			return nil
+		}
+	}
+
+	return fmt.Errorf("candidates:%v have no replica of clone source:%v", hosts, srcHosts)
+}
+
+// nodes returns all nodes with their replication addresses, see network.
+// Nodes without replication address are no candidates.
 func (p *flexProvisioner) nodes() (hosts, ips []string, err error) {
 	nodes, err := p.client.CoreV1().Nodes().List(metav1.ListOptions{})
 	if err != nil {
@@ -47,18 +72,20 @@ func (p *flexProvisioner) nodes() (hosts, ips []string, err error) {
 
 	for _, node := range nodes.Items {
 		var host string
-		var ip string
 
 		for _, addr := range node.Status.Addresses {
-			switch addr.Type {
-			case v1.NodeInternalIP:
-				ip = addr.Address
-			case v1.NodeHostName:
+			if addr.Type == v1.NodeHostName {
 				host = addr.Address
 			}
 		}
+
+		addrs, err := p.network.addresses(&node)
+		if err != nil {
+			glog.Warningf("%s: no candidate: %v", host, err)
+			continue
+		}
 		hosts = append(hosts, host)
-		ips = append(ips, ip)
+		ips = append(ips, res.JoinPaths(addrs))
 	}
 
 	return
@@ -5,6 +5,7 @@ package stor
 
 import (
 	"sort"
+	"strings"
 	"testing"
 )
 
@@ -47,3 +48,19 @@ func TestBySizeNr(t *testing.T) {
 	t.Log(hosts)
 	t.Log(ips)
 }
+
+func TestSeedFirst(t *testing.T) {
+	hosts := []string{"node1", "node2", "node3", "node4"}
+	ips := []string{"ip1", "ip2", "ip3", "ip4"}
+
+	if err := seedFirst(hosts, ips, []string{"node5", "node3"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(hosts, ",") != "node3,node1,node2,node4" || strings.Join(ips, ",") != "ip3,ip1,ip2,ip4" {
+		t.Fatal(hosts, ips)
+	}
+
+	if err := seedFirst(hosts, ips, []string{"node5"}); err == nil {
+		t.Fatal("expect error without source replica")
+	}
+}
@@ -4,24 +4,39 @@
 package stor
 
 import (
+	"crypto/sha256"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/ctriple/drbd/pkg/defs"
 	"github.com/golang/glog"
 
 	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	batchv1client "k8s.io/client-go/kubernetes/typed/batch/v1"
+	"k8s.io/kubernetes/pkg/kubelet/apis"
 )
 
 var (
 	SyncJobNamespace      string
 	SyncJobPodImage       string
 	SyncJobServiceAccount string
+
+	// How long a sync job may take until it completes, including waiting
+	// to be scheduled
+	SyncJobTimeout = time.Hour
 )
 
 const (
 	SyncJobGenerateName  = "sync-"
 	SyncJobContainerName = "sync"
+
+	syncJobPollInterval = 2 * time.Second
 )
 
 func init() {
@@ -174,3 +189,138 @@ func syncJob() *batchv1.Job {
 
 	return job
 }
+
+// runSyncJob runs job on the kubernetes node host, and waits until the job
+// completes or fails, at most SyncJobTimeout.
+//
+// The job is named after its resource, host and step, so that a job left
+// running by a provisioner before this one, such as before a restart or a
+// leader change, is adopted instead of racing a second one on the host. The
+// job is deleted once its result is known, the next run of the step starts
+// afresh.
+func runSyncJob(jobClient batchv1client.JobInterface, job *batchv1.Job, host string) error {
+	name := syncJobName(job)
+	start := time.Now()
+
+	syncJobsRunning.WithLabelValues(name).Inc()
+	defer syncJobsRunning.WithLabelValues(name).Dec()
+
+	job = job.DeepCopy()
+	job.Spec.Template.Spec.NodeSelector = map[string]string{apis.LabelHostname: host}
+	c := &job.Spec.Template.Spec.Containers[0]
+	c.Env = append(c.Env, v1.EnvVar{Name: defs.SyncJob_EnvNode, Value: host})
+
+	step := syncJobStep(job)
+	job.GenerateName = ""
+	job.Name = syncJobID(env(job, defs.SyncJob_EnvResName), host, step)
+	if job.Annotations == nil {
+		job.Annotations = map[string]string{}
+	}
+	job.Annotations[defs.AnnSyncJob] = env(job, defs.SyncJob_EnvResName) + "/" + host + "/" + step
+
+	newJob, err := createSyncJob(jobClient, job)
+	if err != nil {
+		syncJobFailures.WithLabelValues(name, reasonCreate).Inc()
+		return err
+	}
+	defer func() {
+		syncJobDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	}()
+
+	finished := false
+	err = wait.PollImmediate(syncJobPollInterval, SyncJobTimeout, func() (bool, error) {
+		getJob, err := jobClient.Get(newJob.Name, metav1.GetOptions{IncludeUninitialized: true})
+		if err != nil {
+			syncJobFailures.WithLabelValues(name, reasonGet).Inc()
+			return false, err
+		}
+		for _, c := range getJob.Status.Conditions {
+			switch c.Type {
+			case batchv1.JobComplete:
+				finished = true
+				return true, nil
+			case batchv1.JobFailed:
+				finished = true
+				syncJobFailures.WithLabelValues(name, reasonFailed).Inc()
+				return false, fmt.Errorf("job %s failed on %s: %s", newJob.Name, host, c.Message)
+			}
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		syncJobFailures.WithLabelValues(name, reasonTimeout).Inc()
+		err = fmt.Errorf("job %s on %s not complete after %v", newJob.Name, host, SyncJobTimeout)
+	}
+
+	// Unless finished, the job is left to be adopted by the next run
+	if finished {
+		background := metav1.DeletePropagationBackground
+		derr := jobClient.Delete(newJob.Name, &metav1.DeleteOptions{PropagationPolicy: &background})
+		if derr != nil && !apierrors.IsNotFound(derr) {
+			glog.Warningf("delete job %s: %v", newJob.Name, derr)
+		}
+	}
+
+	return err
+}
+
+// createSyncJob creates job, or adopts the job of the same name still there.
+// A job still being deleted is waited for.
+func createSyncJob(jobClient batchv1client.JobInterface, job *batchv1.Job) (*batchv1.Job, error) {
+	var adopted *batchv1.Job
+
+	err := wait.PollImmediate(syncJobPollInterval, SyncJobTimeout, func() (bool, error) {
+		existing, err := jobClient.Get(job.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if existing.DeletionTimestamp != nil {
+			return false, nil
+		}
+		adopted = existing
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if adopted != nil {
+		glog.Infof("adopted job %s: %s", adopted.Name, adopted.Annotations[defs.AnnSyncJob])
+		return adopted, nil
+	}
+	return jobClient.Create(job)
+}
+
+// syncJobID returns the job name of step of resource on host. Resource and
+// host names may be too long for a job name, they are hashed.
+func syncJobID(resName, host, step string) string {
+	sum := sha256.Sum256([]byte(resName + "/" + host))
+	return fmt.Sprintf("%s%s-%x", SyncJobGenerateName, step, sum[:8])
+}
+
+// syncJobStep returns the step job runs, such as new or check
+func syncJobStep(job *batchv1.Job) string {
+	if env(job, defs.SyncJob_EnvArchive) != "" {
+		return "archive"
+	}
+	step := strings.TrimPrefix(syncJobName(job), "SYNCJOB_")
+	return strings.Replace(strings.ToLower(step), "_", "-", -1)
+}
+
+// syncJobName returns what job does, see defs.SyncJob
+func syncJobName(job *batchv1.Job) string {
+	return env(job, defs.SyncJob_EnvJob)
+}
+
+// env returns the value of environment variable name of job
+func env(job *batchv1.Job, name string) string {
+	for _, env := range job.Spec.Template.Spec.Containers[0].Env {
+		if env.Name == name {
+			return env.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package stor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ctriple/drbd/pkg/defs"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSyncJobID(t *testing.T) {
+	long := strings.Repeat("x", 200)
+	id := syncJobID(long, long, "clear-bitmap")
+	if len(id) > 63 {
+		t.Fatalf("job name longer than a label value: %s", id)
+	}
+	if id != syncJobID(long, long, "clear-bitmap") {
+		t.Fatal("expect same name for same resource, host and step")
+	}
+	if id == syncJobID(long, "node1", "clear-bitmap") || id == syncJobID(long, long, "new") {
+		t.Fatal("expect different names for different hosts and steps")
+	}
+}
+
+func TestSyncJobStep(t *testing.T) {
+	job := syncJob()
+	job.Spec.Template.Spec.Containers[0].Env = []v1.EnvVar{
+		{Name: defs.SyncJob_EnvJob, Value: defs.SyncJob_ClearBitmap},
+	}
+	if step := syncJobStep(job); step != "clear-bitmap" {
+		t.Fatalf("unexpected step: %s", step)
+	}
+
+	job.Spec.Template.Spec.Containers[0].Env = []v1.EnvVar{
+		{Name: defs.SyncJob_EnvJob, Value: defs.SyncJob_Del},
+		{Name: defs.SyncJob_EnvArchive, Value: defs.Archive_Zstd},
+	}
+	if step := syncJobStep(job); step != "archive" {
+		t.Fatalf("unexpected step: %s", step)
+	}
+}
+
+func TestRunSyncJobAdopts(t *testing.T) {
+	job := syncJob()
+	job.Spec.Template.Spec.Containers[0].Env = []v1.EnvVar{
+		{Name: defs.SyncJob_EnvJob, Value: defs.SyncJob_New},
+		{Name: defs.SyncJob_EnvResName, Value: "ns-pvc"},
+	}
+
+	// Completed while no provisioner was running
+	name := syncJobID("ns-pvc", "node1", "new")
+	client := fake.NewSimpleClientset(&batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: SyncJobNamespace},
+		Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+			{Type: batchv1.JobComplete, Status: v1.ConditionTrue},
+		}},
+	})
+	jobClient := client.BatchV1().Jobs(SyncJobNamespace)
+
+	if err := runSyncJob(jobClient, job, "node1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jobClient.Get(name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("expect adopted job deleted once finished: %v", err)
+	}
+}
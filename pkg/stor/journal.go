@@ -0,0 +1,92 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package stor
+
+import (
+	"encoding/json"
+
+	"github.com/ctriple/drbd/pkg/defs"
+	"github.com/ctriple/drbd/pkg/sync/res"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// journal records provisioning progress of a pvc in its annotation, so that a
+// restarted or retried Provision resumes with the same hosts instead of
+// leaving disks and resource files behind on hosts nobody remembers.
+//
+// The journal is removed once provisioning succeeded and the DrbdResource of
+// the resource records its hosts. The pv may not have been created yet when
+// the provisioner stops, the next Provision takes the journal from there.
+type journal struct {
+	Hosts    []string `json:"hosts"`
+	IPs      []string `json:"ips"`
+	Diskless []string `json:"diskless,omitempty"`
+
+	// Hosts where SyncJob_New was started and completed
+	Started []string `json:"started,omitempty"`
+	Done    []string `json:"done,omitempty"`
+
+	// Initial sync skipped
+	Cleared bool `json:"cleared,omitempty"`
+}
+
+// loadJournal returns the journal of claim, or of its resource resName already
+// provisioned, nil if provisioning of claim has not started yet.
+func (p *flexProvisioner) loadJournal(claim *v1.PersistentVolumeClaim, resName string) (*journal, error) {
+	pvc, err := p.client.CoreV1().PersistentVolumeClaims(claim.Namespace).Get(claim.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	data, ok := pvc.Annotations[defs.AnnJournal]
+	if !ok {
+		return p.resourceJournal(resName)
+	}
+
+	j := &journal{}
+	if err := json.Unmarshal([]byte(data), j); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// saveJournal persists j in claim, or removes the journal of claim if j is nil
+func (p *flexProvisioner) saveJournal(claim *v1.PersistentVolumeClaim, j *journal) error {
+	pvcClient := p.client.CoreV1().PersistentVolumeClaims(claim.Namespace)
+
+	pvc, err := pvcClient.Get(claim.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	if j == nil {
+		delete(pvc.Annotations, defs.AnnJournal)
+	} else {
+		data, err := json.Marshal(j)
+		if err != nil {
+			return err
+		}
+		if pvc.Annotations == nil {
+			pvc.Annotations = map[string]string{}
+		}
+		pvc.Annotations[defs.AnnJournal] = string(data)
+	}
+
+	_, err = pvcClient.Update(pvc)
+	return err
+}
+
+// diskful returns the journal hosts with backing disk
+func (j *journal) diskful() []string {
+	var hosts []string
+	for _, h := range j.Hosts {
+		if !res.Contains(j.Diskless, h) {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
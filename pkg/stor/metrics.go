@@ -0,0 +1,61 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package stor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "drbd"
+	metricsSubsystem = "stor"
+)
+
+var (
+	syncJobDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "sync_job_duration_seconds",
+			Help:      "Latency in seconds of sync jobs, from creation to completion or failure. Broken down by job.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+		},
+		[]string{"job"},
+	)
+	syncJobFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "sync_job_failures_total",
+			Help:      "Total number of failed sync jobs. Broken down by job and reason.",
+		},
+		[]string{"job", "reason"},
+	)
+	syncJobsRunning = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "sync_jobs_running",
+			Help:      "Number of sync jobs waited for right now. Broken down by job.",
+		},
+		[]string{"job"},
+	)
+)
+
+// Sync job failure reasons
+const (
+	reasonCreate  = "create"
+	reasonGet     = "get"
+	reasonFailed  = "failed"
+	reasonTimeout = "timeout"
+)
+
+// Metrics returns the collectors of sync jobs run by this provisioner
+func Metrics() []prometheus.Collector {
+	return []prometheus.Collector{
+		syncJobDuration,
+		syncJobFailures,
+		syncJobsRunning,
+	}
+}
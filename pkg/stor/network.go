@@ -0,0 +1,183 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package stor
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/ctriple/drbd/pkg/defs"
+	"github.com/ctriple/drbd/pkg/sync/res"
+
+	"k8s.io/api/core/v1"
+)
+
+// Address family of replication on dual-stack nodes
+const (
+	familyIPv4 = "ipv4"
+	familyIPv6 = "ipv6"
+)
+
+// network chooses the DRBD replication addresses of nodes, one for each DRBD
+// path between two nodes. Addresses set on a node by hand come first, then
+// those in cidrs or on interfaces, otherwise the node InternalIP. Addresses
+// on interfaces and InternalIPs are of family, all nodes of a resource must
+// use the same.
+type network struct {
+	cidrs      []*net.IPNet
+	interfaces []string
+	family     string
+}
+
+// SetReplicationNetwork keeps replication off the pod network, each of cidrs
+// or interfaces is a path of its own. Interface addresses are those published
+// by the node agents. family is ipv4 or ipv6.
+func (p *flexProvisioner) SetReplicationNetwork(cidrs, interfaces []string, family string) error {
+	if family != familyIPv4 && family != familyIPv6 {
+		return fmt.Errorf("family: %q must be %s or %s", family, familyIPv4, familyIPv6)
+	}
+
+	n := network{family: family}
+	for _, c := range cidrs {
+		_, cidr, err := net.ParseCIDR(c)
+		if err != nil {
+			return err
+		}
+		n.cidrs = append(n.cidrs, cidr)
+	}
+	n.interfaces = interfaces
+
+	if len(n.cidrs) > 0 && len(n.interfaces) > 0 {
+		return fmt.Errorf("replication network by both cidr and interface")
+	}
+	p.network = n
+
+	return nil
+}
+
+// ifaddr is an interface address published by the node agent
+type ifaddr struct {
+	iface string
+	ip    net.IP
+}
+
+// addresses returns the replication addresses of node, one for each path
+func (n network) addresses(node *v1.Node) ([]string, error) {
+	if addrs := node.Annotations[defs.AnnReplicationAddress]; addrs != "" {
+		var ips []string
+		for _, a := range strings.Split(addrs, ",") {
+			ip := net.ParseIP(strings.TrimSpace(a))
+			if ip == nil {
+				return nil, fmt.Errorf("%s: invalid address %q", defs.AnnReplicationAddress, a)
+			}
+			ips = append(ips, ip.String())
+		}
+		return ips, nil
+	}
+
+	published := publishedAddresses(node)
+
+	var ips []string
+	switch {
+	case len(n.cidrs) > 0:
+		for _, cidr := range n.cidrs {
+			ip := inCIDR(cidr, append(published, internalIPs(node)...))
+			if ip == nil {
+				return nil, fmt.Errorf("no address in %s", cidr)
+			}
+			ips = append(ips, ip.String())
+		}
+
+	case len(n.interfaces) > 0:
+		for _, iface := range n.interfaces {
+			ip := onInterface(iface, n.family, published)
+			if ip == nil {
+				return nil, fmt.Errorf("no %s address on %s", n.family, iface)
+			}
+			ips = append(ips, ip.String())
+		}
+
+	default:
+		ip := onInterface("", n.family, internalIPs(node))
+		if ip == nil {
+			return nil, fmt.Errorf("no %s %s", n.family, v1.NodeInternalIP)
+		}
+		ips = append(ips, ip.String())
+	}
+
+	return ips, nil
+}
+
+// publishedAddresses parses the interface addresses of node, see agent
+func publishedAddresses(node *v1.Node) []ifaddr {
+	var addrs []ifaddr
+	for _, a := range strings.Split(node.Annotations[defs.AnnAddresses], ",") {
+		kv := strings.SplitN(a, "=", 2)
+		if len(kv) < 2 {
+			continue
+		}
+		if ip := net.ParseIP(kv[1]); ip != nil {
+			addrs = append(addrs, ifaddr{iface: kv[0], ip: ip})
+		}
+	}
+	return addrs
+}
+
+func internalIPs(node *v1.Node) []ifaddr {
+	var addrs []ifaddr
+	for _, a := range node.Status.Addresses {
+		if a.Type != v1.NodeInternalIP {
+			continue
+		}
+		if ip := net.ParseIP(a.Address); ip != nil {
+			addrs = append(addrs, ifaddr{ip: ip})
+		}
+	}
+	return addrs
+}
+
+func inCIDR(cidr *net.IPNet, addrs []ifaddr) net.IP {
+	for _, a := range addrs {
+		if cidr.Contains(a.ip) {
+			return a.ip
+		}
+	}
+	return nil
+}
+
+// onInterface returns the first address of family on iface
+func onInterface(iface, family string, addrs []ifaddr) net.IP {
+	for _, a := range addrs {
+		if a.iface == iface && ipFamily(a.ip) == family {
+			return a.ip
+		}
+	}
+	return nil
+}
+
+// samePaths returns true if replication addresses a and b, joined by
+// res.JoinPaths, have as many paths, each of one address family on both. DRBD
+// only connects two hosts over the paths both have, and can not connect an
+// IPv4 address to an IPv6 one.
+func samePaths(a, b string) bool {
+	pathsA, pathsB := res.SplitPaths(a), res.SplitPaths(b)
+	if len(pathsA) != len(pathsB) {
+		return false
+	}
+	for i := range pathsA {
+		ipA, ipB := net.ParseIP(pathsA[i]), net.ParseIP(pathsB[i])
+		if ipA == nil || ipB == nil || ipFamily(ipA) != ipFamily(ipB) {
+			return false
+		}
+	}
+	return true
+}
+
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return familyIPv4
+	}
+	return familyIPv6
+}
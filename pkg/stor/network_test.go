@@ -0,0 +1,97 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package stor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ctriple/drbd/pkg/defs"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAddresses(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node1",
+			Annotations: map[string]string{
+				defs.AnnAddresses: "eth0=172.25.33.11,eth0=fd00:33::11,eth1=fd00::11,eth1=10.1.0.11,eth2=10.2.0.11",
+			},
+		},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "172.25.33.11"},
+				{Type: v1.NodeInternalIP, Address: "fd00:33::11"},
+				{Type: v1.NodeHostName, Address: "node1"},
+			},
+		},
+	}
+	p := &flexProvisioner{}
+
+	for _, c := range []struct {
+		cidrs, interfaces []string
+		family            string
+		expect            string
+	}{
+		{nil, nil, "ipv4", "172.25.33.11"},
+		{nil, nil, "ipv6", "fd00:33::11"},
+		{[]string{"10.1.0.0/16"}, nil, "ipv4", "10.1.0.11"},
+		{[]string{"10.2.0.0/16", "10.1.0.0/16"}, nil, "ipv4", "10.2.0.11,10.1.0.11"},
+		{[]string{"fd00::/64"}, nil, "ipv4", "fd00::11"},
+		{nil, []string{"eth1"}, "ipv4", "10.1.0.11"},
+		{nil, []string{"eth1"}, "ipv6", "fd00::11"},
+		{nil, []string{"eth1", "eth2"}, "ipv4", "10.1.0.11,10.2.0.11"},
+	} {
+		if err := p.SetReplicationNetwork(c.cidrs, c.interfaces, c.family); err != nil {
+			t.Fatal(err)
+		}
+		addrs, err := p.network.addresses(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(addrs, ",") != c.expect {
+			t.Fatalf("cidrs:%v interfaces:%v family:%s unexpected addresses: %v", c.cidrs, c.interfaces, c.family, addrs)
+		}
+	}
+
+	if err := p.SetReplicationNetwork([]string{"10.3.0.0/16"}, nil, "ipv4"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.network.addresses(node); err == nil {
+		t.Fatal("expect error without address in cidr")
+	}
+	if err := p.SetReplicationNetwork(nil, []string{"eth2"}, "ipv6"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.network.addresses(node); err == nil {
+		t.Fatal("expect error without ipv6 address on interface")
+	}
+
+	// Set by hand
+	node.Annotations[defs.AnnReplicationAddress] = "10.9.0.11, fd00:9:0::11"
+	addrs, err := p.network.addresses(node)
+	if err != nil || strings.Join(addrs, ",") != "10.9.0.11,fd00:9::11" {
+		t.Fatalf("unexpected addresses: %v %v", addrs, err)
+	}
+}
+
+func TestSamePaths(t *testing.T) {
+	for _, c := range []struct {
+		a, b   string
+		expect bool
+	}{
+		{"10.1.0.11", "10.1.0.12", true},
+		{"10.1.0.11", "fd00::12", false},
+		{"10.1.0.11;fd00::11", "10.1.0.12;fd00::12", true},
+		{"10.1.0.11;fd00::11", "10.1.0.12;10.2.0.12", false},
+		{"10.1.0.11;10.2.0.11", "10.1.0.12", false},
+		{"10.1.0.11", "10.1.0.12;10.2.0.12", false},
+	} {
+		if samePaths(c.a, c.b) != c.expect {
+			t.Fatalf("%s and %s: expect same paths %v", c.a, c.b, c.expect)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package stor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ctriple/drbd/pkg/crd"
+	"github.com/ctriple/drbd/pkg/sync/res"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// createResource records the spec of a provisioned resource in its
+// DrbdResource. The resource works without it.
+func (p *flexProvisioner) createResource(resName, resSize string, j *journal, opts res.Options) error {
+	r := &crd.DrbdResource{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: resName,
+		},
+		Spec: crd.DrbdResourceSpec{
+			Size:     resSize,
+			Port:     res.Port(resName),
+			Minor:    res.Minor(resName),
+			Replicas: len(j.diskful()),
+			Options: crd.DrbdOptions{
+				Net:      opts.Net,
+				Disk:     opts.Disk,
+				Resource: opts.Resource,
+			},
+		},
+	}
+	for i, h := range j.Hosts {
+		r.Spec.Nodes = append(r.Spec.Nodes, crd.DrbdNode{
+			Name:     h,
+			Address:  strings.Join(res.SplitPaths(j.IPs[i]), ","),
+			NodeID:   i,
+			Diskless: res.Contains(j.Diskless, h),
+		})
+	}
+
+	err := crd.Create(p.client.CoreV1().RESTClient(), r)
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// resourceJournal returns the journal of a provisioned resource from its
+// DrbdResource, nil if it has none.
+func (p *flexProvisioner) resourceJournal(resName string) (*journal, error) {
+	r, err := crd.Get(p.client.CoreV1().RESTClient(), resName)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := append([]crd.DrbdNode{}, r.Spec.Nodes...)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].NodeID < nodes[j].NodeID })
+
+	j := &journal{Cleared: true}
+	for _, n := range nodes {
+		j.Hosts = append(j.Hosts, n.Name)
+		j.IPs = append(j.IPs, res.JoinPaths(strings.Split(n.Address, ",")))
+		if n.Diskless {
+			j.Diskless = append(j.Diskless, n.Name)
+		}
+	}
+	j.Started = append([]string{}, j.Hosts...)
+	j.Done = append([]string{}, j.Hosts...)
+
+	return j, nil
+}
+
+// deleteResource deletes the DrbdResource of a deleted resource, a resource
+// left behind would be taken as provisioned by a new pvc of the same name.
+func (p *flexProvisioner) deleteResource(resName string) error {
+	err := crd.Delete(p.client.CoreV1().RESTClient(), resName)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("delete %s %s: %v", crd.Kind, resName, err)
+	}
+	return nil
+}
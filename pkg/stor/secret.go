@@ -0,0 +1,59 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package stor
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/ctriple/drbd/pkg/defs"
+	"github.com/golang/glog"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// peerSecret makes sure the peer Secret of resource exists, a retried
+// provision keeps the secret generated first. It returns the Secret name.
+func (p *flexProvisioner) peerSecret(resName string) (string, error) {
+	name := defs.PeerSecretPrefix + resName
+	secretClient := p.client.CoreV1().Secrets(SyncJobNamespace)
+
+	_, err := secretClient.Get(name, metav1.GetOptions{})
+	if err == nil {
+		return name, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{pvCreatedBy: defs.DrbdDriver},
+		},
+		Data: map[string][]byte{
+			defs.PeerSecretKey: []byte(hex.EncodeToString(b)),
+		},
+	}
+	if _, err := secretClient.Create(secret); err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// deletePeerSecret deletes the peer Secret of a deleted or rolled back resource
+func (p *flexProvisioner) deletePeerSecret(resName string) {
+	name := defs.PeerSecretPrefix + resName
+	err := p.client.CoreV1().Secrets(SyncJobNamespace).Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		glog.Warningf("%s: delete secret %s: %v", resName, name, err)
+	}
+}
@@ -7,11 +7,14 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ctriple/drbd/pkg/defs"
+	"github.com/ctriple/drbd/pkg/sync/lvm"
+	"github.com/ctriple/drbd/pkg/sync/res"
+	"github.com/golang/glog"
 	"github.com/kubernetes-sigs/sig-storage-lib-external-provisioner/controller"
 
-	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -20,7 +23,7 @@ import (
 )
 
 const (
-	pvCreatedBy = "kubernetes.io/createdby"
+	pvCreatedBy = defs.AnnCreatedBy
 )
 
 var _ controller.Provisioner = &flexProvisioner{}
@@ -28,6 +31,7 @@ var _ controller.Provisioner = &flexProvisioner{}
 type flexProvisioner struct {
 	client   kubernetes.Interface
 	identity types.UID
+	network  network
 }
 
 func NewFlexProvisioner(client kubernetes.Interface) *flexProvisioner {
@@ -36,6 +40,7 @@ func NewFlexProvisioner(client kubernetes.Interface) *flexProvisioner {
 	flexProvisioner := &flexProvisioner{
 		client:   client,
 		identity: identity,
+		network:  network{family: familyIPv4},
 	}
 
 	return flexProvisioner
@@ -47,6 +52,14 @@ func (p *flexProvisioner) Provision(options controller.VolumeOptions) (*v1.Persi
 
 	replicas := defs.DrbdReplicaMin
 	fstype := "ext4"
+	clone := defs.Clone_Snapshot
+	tiebreaker := false
+	archive := ""
+	archiveDir := defs.ArchiveDir
+	archiveRetention := defs.ArchiveRetention
+	encryption := ""
+	encryptionSecret := ""
+	resOpts := res.DefaultOptions()
 
 	for k, v := range options.Parameters {
 		switch strings.ToLower(k) {
@@ -65,22 +78,102 @@ func (p *flexProvisioner) Provision(options controller.VolumeOptions) (*v1.Persi
 			}
 		case "fstype":
 			fstype = v
+		case "clonemethod":
+			clone = v
+		case "tiebreaker":
+			tiebreaker = v == "true"
+		case "archive":
+			if v != defs.Archive_Zstd && v != defs.Archive_Rename {
+				return nil, fmt.Errorf("archive: %q must be %s or %s", v, defs.Archive_Zstd, defs.Archive_Rename)
+			}
+			archive = v
+		case "archivedir":
+			if err := lvm.ValidArchiveDir(v); err != nil {
+				return nil, fmt.Errorf("archivedir: %v", err)
+			}
+			archiveDir = v
+		case "archiveretention":
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("archiveretention: %v", err)
+			}
+			archiveRetention = d
+		case "encryption":
+			if v != defs.Encryption_Below && v != defs.Encryption_Above {
+				return nil, fmt.Errorf("encryption: %q must be %s or %s", v, defs.Encryption_Below, defs.Encryption_Above)
+			}
+			encryption = v
+		case "encryptionsecret":
+			encryptionSecret = v
+		default:
+			// DRBD net and disk tuning, anything else is a typo
+			known, err := resOpts.Set(strings.ToLower(k), v)
+			if err != nil {
+				return nil, err
+			}
+			if !known {
+				return nil, fmt.Errorf("unknown parameter %q", k)
+			}
+		}
+	}
+
+	// -- Encryption key from a Secret in our namespace, so that sync jobs
+	// can read it
+	if encryption != "" {
+		if err := p.checkEncryptionSecret(encryptionSecret); err != nil {
+			return nil, err
+		}
+		if options.PVC.Spec.DataSource != nil {
+			return nil, fmt.Errorf("encryption: cloning into encrypted volumes is not supported")
+		}
+	}
+
+	// -- Cloning from an existing pvc, the data is seeded on one host which
+	// already has the source replica.
+	var source *v1.PersistentVolume
+	var quiesced string
+	if options.PVC.Spec.DataSource != nil {
+		if clone != defs.Clone_Snapshot && clone != defs.Clone_Copy {
+			return nil, fmt.Errorf("clonemethod: %q must be %s or %s", clone, defs.Clone_Snapshot, defs.Clone_Copy)
+		}
+
+		var err error
+		source, quiesced, err = p.cloneSource(options.PVC)
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	resName := fmt.Sprintf("%s-%s", options.PVC.ObjectMeta.Namespace, options.PVC.ObjectMeta.Name)
 	resSize := fmt.Sprintf("%dM", (requestedBytes/1024/1024 + 1))
+	if encryption != "" {
+		resSize = fmt.Sprintf("%dM", (requestedBytes/1024/1024 + 1 + defs.EncryptionHeaderMB))
+	}
 
-	// -- Use our host choosen algorithm
-	hosts, ips, err := p.candidates()
+	// -- Resume a provisioning interrupted before, or use our host choosen
+	// algorithm
+	j, err := p.loadJournal(options.PVC, resName)
 	if err != nil {
 		return nil, err
 	}
-	if len(hosts) < replicas {
-		return nil, fmt.Errorf("candidates:%v less than exptected replicas:%d", hosts, replicas)
+	if j == nil {
+		j, err = p.place(replicas, tiebreaker, source)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.saveJournal(options.PVC, j); err != nil {
+			return nil, err
+		}
+	}
+	hosts, ips, diskless := j.Hosts, j.IPs, j.Diskless
+	resOpts.Quorum(len(hosts))
+
+	// -- Peers authenticate each other with a shared secret of this volume,
+	// so that no other host reaching the port can connect as a peer
+	peerSecret, err := p.peerSecret(resName)
+	if err != nil {
+		return nil, err
 	}
-	hosts = hosts[:replicas]
-	ips = ips[:replicas]
 
 	// -- Run sync job on each choosen host
 
@@ -94,41 +187,77 @@ func (p *flexProvisioner) Provision(options controller.VolumeOptions) (*v1.Persi
 		{Name: defs.SyncJob_EnvResHost, Value: strings.Join(hosts, ",")},
 		{Name: defs.SyncJob_EnvResIP, Value: strings.Join(ips, ",")},
 	}
-	syncJob.Spec.Template.Spec.Containers[0].Env = jobEnvs
+	resNet, resDisk, resResource := resOpts.Env()
+	jobEnvs = append(jobEnvs,
+		v1.EnvVar{Name: defs.SyncJob_EnvResNet, Value: resNet},
+		v1.EnvVar{Name: defs.SyncJob_EnvResDisk, Value: resDisk},
+		v1.EnvVar{Name: defs.SyncJob_EnvResOpts, Value: resResource},
+		v1.EnvVar{Name: defs.SyncJob_EnvResDiskless, Value: strings.Join(diskless, ",")},
+	)
+	jobEnvs = append(jobEnvs, v1.EnvVar{
+		Name: defs.SyncJob_EnvResSecret,
+		ValueFrom: &v1.EnvVarSource{
+			SecretKeyRef: &v1.SecretKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: peerSecret},
+				Key:                  defs.PeerSecretKey,
+			},
+		},
+	})
+	if encryption == defs.Encryption_Below {
+		jobEnvs = append(jobEnvs,
+			v1.EnvVar{Name: defs.SyncJob_EnvEncryption, Value: encryption},
+			v1.EnvVar{
+				Name: defs.SyncJob_EnvEncryptionKey,
+				ValueFrom: &v1.EnvVarSource{
+					SecretKeyRef: &v1.SecretKeySelector{
+						LocalObjectReference: v1.LocalObjectReference{Name: encryptionSecret},
+						Key:                  defs.EncryptionSecretKey,
+					},
+				},
+			},
+		)
+	}
 
 	complete := []string{}
 	failed := []string{}
 
-	for _, h := range hosts {
-		// Run job on this host
-		syncJob.Spec.Template.Spec.NodeSelector = map[string]string{apis.LabelHostname: h}
-		newJob, err := jobClient.Create(syncJob)
-		if err != nil {
-			failed = append(failed, h)
+	for i, h := range hosts {
+		if res.Contains(j.Done, h) {
+			complete = append(complete, h)
 			continue
 		}
 
-	ThisJobExit:
-		for {
-			getJob, err := jobClient.Get(newJob.Name, metav1.GetOptions{IncludeUninitialized: true})
-			if err != nil {
-				failed = append(failed, h)
-				break
-			}
-			for _, c := range getJob.Status.Conditions {
-				switch c.Type {
-				case batchv1.JobComplete:
-					complete = append(complete, h)
-					break ThisJobExit
-				case batchv1.JobFailed:
-					failed = append(failed, h)
-					break ThisJobExit
-				}
+		// The first host seeds the cloned data, see seedFirst
+		syncJob.Spec.Template.Spec.Containers[0].Env = jobEnvs
+		if source != nil && i == 0 {
+			syncJob.Spec.Template.Spec.Containers[0].Env = append([]v1.EnvVar{
+				{Name: defs.SyncJob_EnvResSource, Value: source.Name},
+				{Name: defs.SyncJob_EnvResClone, Value: clone},
+				{Name: defs.SyncJob_EnvResQuiesced, Value: quiesced},
+			}, jobEnvs...)
+		}
+
+		// Run job on this host, and record progress before and after
+		if !res.Contains(j.Started, h) {
+			j.Started = append(j.Started, h)
+			if err := p.saveJournal(options.PVC, j); err != nil {
+				return nil, err
 			}
 		}
+		if err := runSyncJob(jobClient, syncJob, h); err != nil {
+			failed = append(failed, h)
+			continue
+		}
+		complete = append(complete, h)
+		j.Done = append(j.Done, h)
+		if err := p.saveJournal(options.PVC, j); err != nil {
+			return nil, err
+		}
 	}
 
-	// -- Partially completion, should clean up already completed host
+	// -- Partially completion, should clean up all started host, including
+	// those partially done. Next retry starts over once all of them are
+	// rolled back, until then the journal keeps the hosts left.
 	if len(complete) < len(hosts) {
 		jobEnvs := []v1.EnvVar{
 			{Name: defs.SyncJob_EnvJob, Value: defs.SyncJob_Del},
@@ -139,24 +268,93 @@ func (p *flexProvisioner) Provision(options controller.VolumeOptions) (*v1.Persi
 		}
 		syncJob.Spec.Template.Spec.Containers[0].Env = jobEnvs
 
-		for _, h := range complete {
-			syncJob.Spec.Template.Spec.NodeSelector = map[string]string{apis.LabelHostname: h}
-			jobClient.Create(syncJob)
+		var started, done []string
+		for _, h := range j.Started {
+			if err := runSyncJob(jobClient, syncJob, h); err != nil {
+				glog.Errorf("%s: roll back on %s: %v", resName, h, err)
+				started = append(started, h)
+				if res.Contains(j.Done, h) {
+					done = append(done, h)
+				}
+			}
+		}
+		j.Started, j.Done = started, done
+
+		if len(started) > 0 {
+			if err := p.saveJournal(options.PVC, j); err != nil {
+				glog.Errorf("%s: save journal: %v", resName, err)
+			}
+			return nil, fmt.Errorf("Sync job complete:%v failed:%v, roll back left:%v", complete, failed, started)
+		}
+		p.deletePeerSecret(resName)
+		if err := p.saveJournal(options.PVC, nil); err != nil {
+			glog.Errorf("%s: drop journal: %v", resName, err)
 		}
 
 		return nil, fmt.Errorf("Sync job complete:%v failed:%v", complete, failed)
 	}
 
+	// -- Fresh volumes have nothing worth syncing, let one host start a new
+	// data generation for all connected replicas, so they are UpToDate at
+	// once. Otherwise the initial full sync happens on first use.
+	if source == nil && !j.Cleared {
+		jobEnvs := []v1.EnvVar{
+			{Name: defs.SyncJob_EnvJob, Value: defs.SyncJob_ClearBitmap},
+			{Name: defs.SyncJob_EnvResName, Value: resName},
+			{Name: defs.SyncJob_EnvResSize, Value: "not-used"},
+			{Name: defs.SyncJob_EnvResHost, Value: "not-used"},
+			{Name: defs.SyncJob_EnvResIP, Value: "not-used"},
+		}
+		syncJob.Spec.Template.Spec.Containers[0].Env = jobEnvs
+
+		// Hosts done are kept, a retry only clears the bitmap again
+		if err := runSyncJob(jobClient, syncJob, hosts[0]); err != nil {
+			return nil, fmt.Errorf("skip initial sync of %s on %s: %v", resName, hosts[0], err)
+		}
+		j.Cleared = true
+		if err := p.saveJournal(options.PVC, j); err != nil {
+			return nil, err
+		}
+	}
+
+	// -- The DrbdResource records the hosts from now on, see loadJournal
+	if err := p.createResource(resName, resSize, j, resOpts); err != nil {
+		glog.Warningf("%s: create DrbdResource: %v, journal kept", resName, err)
+	} else if err := p.saveJournal(options.PVC, nil); err != nil {
+		glog.Warningf("%s: drop journal: %v", resName, err)
+	}
+
 	// -- All sync job completed successfully, pv provision ok. Note that
-	// this pv is available only on the drbd nodes.
+	// this pv is available only on the drbd nodes with disk.
+	annotations := map[string]string{
+		pvCreatedBy: defs.DrbdDriver,
+	}
+	if len(diskless) > 0 {
+		annotations[defs.AnnDiskless] = strings.Join(diskless, ",")
+	}
+	if encryption != "" {
+		annotations[defs.AnnEncryption] = encryption
+		annotations[defs.AnnEncryptionSecret] = SyncJobNamespace + "/" + encryptionSecret
+	}
+	if archive != "" {
+		annotations[defs.AnnArchive] = archive
+		annotations[defs.AnnArchiveDir] = archiveDir
+		annotations[defs.AnnArchiveRetention] = archiveRetention.String()
+	}
+	hosts = j.diskful()
+
+	// Only the Primary needs the key encrypted above DRBD, kubelet passes it
+	// to the flexvolume driver
+	var secretRef *v1.SecretReference
+	if encryption == defs.Encryption_Above {
+		secretRef = &v1.SecretReference{Name: encryptionSecret, Namespace: SyncJobNamespace}
+	}
 
 	pv := &v1.PersistentVolume{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:   resName,
-			Labels: map[string]string{},
-			Annotations: map[string]string{
-				pvCreatedBy: defs.DrbdDriver,
-			},
+			Name:        resName,
+			Labels:      map[string]string{},
+			Annotations: annotations,
 		},
 		Spec: v1.PersistentVolumeSpec{
 			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
@@ -171,6 +369,7 @@ func (p *flexProvisioner) Provision(options controller.VolumeOptions) (*v1.Persi
 					Options: map[string]string{
 						"resource": resName,
 					},
+					SecretRef: secretRef,
 				},
 			},
 			NodeAffinity: &v1.VolumeNodeAffinity{
@@ -190,10 +389,101 @@ func (p *flexProvisioner) Provision(options controller.VolumeOptions) (*v1.Persi
 			},
 		},
 	}
+	if encryption == defs.Encryption_Above {
+		pv.Spec.FlexVolume.Options["encryption"] = encryption
+	}
 
 	return pv, nil
 }
 
+// place chooses hosts for a new resource, the first replicas hosts have disk,
+// followed by diskless tiebreaker if asked.
+func (p *flexProvisioner) place(replicas int, tiebreaker bool, source *v1.PersistentVolume) (*journal, error) {
+	hosts, ips, err := p.candidates()
+	if err != nil {
+		return nil, err
+	}
+	if source != nil {
+		srcHosts := source.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Values
+		if err := seedFirst(hosts, ips, srcHosts); err != nil {
+			return nil, err
+		}
+	}
+
+	// A diskless tiebreaker gives 2 replicas a quorum majority
+	tiebreakers := 0
+	if tiebreaker {
+		if replicas != 2 {
+			return nil, fmt.Errorf("tiebreaker: only for 2 replicas, %d replicas have a quorum majority of their own", replicas)
+		}
+		tiebreakers = 1
+	}
+
+	// All hosts have as many paths, each of one address family, the first
+	// host decides, see samePaths
+	var chosen, chosenIPs []string
+	for i, h := range hosts {
+		if len(chosen) == replicas+tiebreakers {
+			break
+		}
+		if len(chosen) > 0 && !samePaths(chosenIPs[0], ips[i]) {
+			glog.Warningf("%s: replication address %s differs in paths from %s of %s, no candidate", h, ips[i], chosenIPs[0], chosen[0])
+			continue
+		}
+		chosen = append(chosen, h)
+		chosenIPs = append(chosenIPs, ips[i])
+	}
+	if len(chosen) < replicas+tiebreakers {
+		return nil, fmt.Errorf("candidates:%v less than exptected replicas:%d tiebreakers:%d", chosen, replicas, tiebreakers)
+	}
+
+	j := &journal{
+		Hosts:    chosen,
+		IPs:      chosenIPs,
+		Diskless: chosen[replicas:],
+	}
+
+	return j, nil
+}
+
+// cloneSource returns the pv bound to the data source pvc of claim, and
+// whether the source pvc is marked quiesced.
+func (p *flexProvisioner) cloneSource(claim *v1.PersistentVolumeClaim) (*v1.PersistentVolume, string, error) {
+	ds := claim.Spec.DataSource
+	if ds.Kind != "PersistentVolumeClaim" || (ds.APIGroup != nil && *ds.APIGroup != "") {
+		return nil, "", fmt.Errorf("dataSource: %s %s not supported, only PersistentVolumeClaim", ds.Kind, ds.Name)
+	}
+
+	srcClaim, err := p.client.CoreV1().PersistentVolumeClaims(claim.Namespace).Get(ds.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	if srcClaim.Spec.VolumeName == "" {
+		return nil, "", fmt.Errorf("dataSource: pvc %s is not bound yet", ds.Name)
+	}
+
+	source, err := p.client.CoreV1().PersistentVolumes().Get(srcClaim.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	if provisioner := source.Annotations[pvCreatedBy]; provisioner != defs.DrbdDriver {
+		return nil, "", fmt.Errorf("dataSource: pv %s not provisioned by: %v", source.Name, defs.DrbdDriver)
+	}
+
+	srcCapacity := source.Spec.Capacity[v1.ResourceStorage]
+	capacity := claim.Spec.Resources.Requests[v1.ResourceStorage]
+	if capacity.Cmp(srcCapacity) < 0 {
+		return nil, "", fmt.Errorf("dataSource: pvc %s capacity %s larger than requested %s", ds.Name, srcCapacity.String(), capacity.String())
+	}
+
+	quiesced := "false"
+	if srcClaim.Annotations[defs.AnnQuiesced] == "true" {
+		quiesced = "true"
+	}
+
+	return source, quiesced, nil
+}
+
 func (p *flexProvisioner) Delete(volume *v1.PersistentVolume) error {
 	// -- pv not provisioned by ctriple.cn/drbd
 	provisioner := volume.Annotations[pvCreatedBy]
@@ -204,6 +494,17 @@ func (p *flexProvisioner) Delete(volume *v1.PersistentVolume) error {
 	resName := volume.Name
 
 	jobClient := p.client.BatchV1().Jobs(SyncJobNamespace)
+	hosts := volume.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Values
+	if diskless := volume.Annotations[defs.AnnDiskless]; diskless != "" {
+		hosts = append(hosts, strings.Split(diskless, ",")...)
+	}
+
+	// Nothing is torn down while the volume is still in use anywhere, the
+	// error makes the controller retry later.
+	if inUse := p.inUse(resName, hosts); len(inUse) > 0 {
+		return fmt.Errorf("volume %s is in use on %v", resName, inUse)
+	}
+
 	syncJob := syncJob()
 	jobEnvs := []v1.EnvVar{
 		{Name: defs.SyncJob_EnvJob, Value: defs.SyncJob_Del},
@@ -213,45 +514,87 @@ func (p *flexProvisioner) Delete(volume *v1.PersistentVolume) error {
 		{Name: defs.SyncJob_EnvResIP, Value: "not-used"},
 	}
 	syncJob.Spec.Template.Spec.Containers[0].Env = jobEnvs
-	hosts := volume.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Values
 
 	complete := []string{}
 	failed := []string{}
 
+	// -- Archive on the first host, no replica is deleted unless archived
+	if archive := volume.Annotations[defs.AnnArchive]; archive != "" {
+		archiveJob := syncJob.DeepCopy()
+		archiveJob.Spec.Template.Spec.Containers[0].Env = append(jobEnvs,
+			v1.EnvVar{Name: defs.SyncJob_EnvArchive, Value: archive},
+			v1.EnvVar{Name: defs.SyncJob_EnvArchiveDir, Value: volume.Annotations[defs.AnnArchiveDir]},
+			v1.EnvVar{Name: defs.SyncJob_EnvArchiveRetention, Value: volume.Annotations[defs.AnnArchiveRetention]},
+		)
+		if err := runSyncJob(jobClient, archiveJob, hosts[0]); err != nil {
+			return fmt.Errorf("archive %s on %s: %v", resName, hosts[0], err)
+		}
+		complete = append(complete, hosts[0])
+		hosts = hosts[1:]
+	}
+
 	for _, h := range hosts {
 		// Run job on this host
-		syncJob.Spec.Template.Spec.NodeSelector = map[string]string{apis.LabelHostname: h}
-		newJob, err := jobClient.Create(syncJob)
-		if err != nil {
+		if err := runSyncJob(jobClient, syncJob, h); err != nil {
 			failed = append(failed, h)
 			continue
 		}
+		complete = append(complete, h)
+	}
 
-	ThisJobExit:
-		for {
-			getJob, err := jobClient.Get(newJob.Name, metav1.GetOptions{IncludeUninitialized: true})
-			if err != nil {
-				failed = append(failed, h)
-				break
-			}
-			for _, c := range getJob.Status.Conditions {
-				switch c.Type {
-				case batchv1.JobComplete:
-					complete = append(complete, h)
-					break ThisJobExit
-				case batchv1.JobFailed:
-					failed = append(failed, h)
-					break ThisJobExit
-				}
-			}
+	// A partial delete is retried by the controller, the hosts done have
+	// nothing left to delete and their sync job skips it. A host which
+	// keeps failing, such as one gone for good, is left to the admin.
+	if len(failed) > 0 {
+		return fmt.Errorf("Sync job complete:%v failed:%v", complete, failed)
+	}
+
+	if err := p.deleteResource(resName); err != nil {
+		return err
+	}
+	p.deletePeerSecret(resName)
+
+	return nil
+}
+
+// inUse returns the hosts where resource is Primary or opened, or can not be
+// checked.
+func (p *flexProvisioner) inUse(resName string, hosts []string) []string {
+	jobClient := p.client.BatchV1().Jobs(SyncJobNamespace)
+
+	// A volume in use stays in use for a while, don't retry the check
+	backoffLimit := int32(0)
+	checkJob := syncJob()
+	checkJob.Spec.BackoffLimit = &backoffLimit
+	checkJob.Spec.Template.Spec.Containers[0].Env = []v1.EnvVar{
+		{Name: defs.SyncJob_EnvJob, Value: defs.SyncJob_Check},
+		{Name: defs.SyncJob_EnvResName, Value: resName},
+	}
+
+	var inUse []string
+	for _, h := range hosts {
+		if err := runSyncJob(jobClient, checkJob, h); err != nil {
+			glog.Warningf("%s: check on %s: %v", resName, h, err)
+			inUse = append(inUse, h)
 		}
 	}
 
-	// FIXME: If ctriple.cn/drbd provisioned pv was deleted partially
-	// completed, i have no idea how to recover from this situation, you
-	// should never use this pv anymore and try to clean up by hand.
-	if len(complete) < len(hosts) {
-		return fmt.Errorf("Sync job complete:%v failed:%v", complete, failed)
+	return inUse
+}
+
+// checkEncryptionSecret makes sure the encryption Secret exists in our
+// namespace with a key
+func (p *flexProvisioner) checkEncryptionSecret(name string) error {
+	if name == "" {
+		return fmt.Errorf("encryptionsecret: required by encryption")
+	}
+
+	secret, err := p.client.CoreV1().Secrets(SyncJobNamespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("encryptionsecret: %v", err)
+	}
+	if len(secret.Data[defs.EncryptionSecretKey]) == 0 {
+		return fmt.Errorf("encryptionsecret: %s/%s has no %q", SyncJobNamespace, name, defs.EncryptionSecretKey)
 	}
 
 	return nil
@@ -0,0 +1,144 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package lvm
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ctriple/drbd/pkg/defs"
+)
+
+// Archived disks are renamed to {name}_archived_{unix time}_{retention}, and
+// images are written as {name}-{unix time}-{retention}.img.zst, so each
+// archive is pruned by its own retention.
+const (
+	archivedInfix = "_archived_"
+	imageSuffix   = ".img.zst"
+)
+
+// archiveDir is what an archive dir may be, it is passed to the host shell
+var archiveDir = regexp.MustCompile(`^/[A-Za-z0-9._/-]+$`)
+
+// ValidArchiveDir returns an error if dir is not an absolute path of plain
+// characters.
+func ValidArchiveDir(dir string) error {
+	if !archiveDir.MatchString(dir) {
+		return fmt.Errorf("%q must be an absolute path of letters, digits and ._/-", dir)
+	}
+	return nil
+}
+
+// Archive renames disk name of vg with the current time and retention, and
+// removes its drbd tag, so that it is neither in the way of a new disk of the
+// same name nor garbage collected.
+func Archive(vg, name string, retention time.Duration) error {
+	newName := fmt.Sprintf("%s%s%d_%s", name, archivedInfix, time.Now().Unix(), retention)
+
+	renamecmd := []string{"lvrename", quote(vg), quote(name), quote(newName)}
+	if _, err := sshexec(strings.Join(renamecmd, " ")); err != nil {
+		return err
+	}
+
+	tagcmd := []string{"lvchange", "--deltag", defs.DrbdDiskTag, quote(path.Join(vg, newName))}
+	if _, err := sshexec(strings.Join(tagcmd, " ")); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Export writes a zstd compressed image of disk into dir on the host, and
+// returns the image file.
+func Export(disk, dir string, retention time.Duration) (string, error) {
+	if err := ValidArchiveDir(dir); err != nil {
+		return "", err
+	}
+	file := path.Join(dir, fmt.Sprintf("%s-%d-%s%s", path.Base(disk), time.Now().Unix(), retention, imageSuffix))
+
+	sshcmd := fmt.Sprintf("set -o pipefail; mkdir -p %s && dd if=%s bs=1M iflag=direct status=none | zstd -q -o %s", quote(dir), quote(disk), quote(file))
+	if _, err := sshexec(sshcmd); err != nil {
+		sshexec("rm -f " + quote(file))
+		return "", err
+	}
+
+	return file, nil
+}
+
+// Prune removes the archived disks of vg and the images in dir which are
+// older than their retention. Archives without retention are kept.
+func Prune(vg, dir string) error {
+	if err := ValidArchiveDir(dir); err != nil {
+		return err
+	}
+
+	lvs, err := List(vg)
+	if err != nil {
+		return err
+	}
+	for _, lv := range lvs {
+		if !expired(lv.Name, archivedInfix, "_") {
+			continue
+		}
+		if err := Remove(path.Join("/dev", vg, lv.Name)); err != nil {
+			return err
+		}
+	}
+
+	lscmd := fmt.Sprintf("[ ! -d %s ] || find %s -maxdepth 1 -name '*%s' -printf '%%f\\n'", quote(dir), quote(dir), imageSuffix)
+	out, err := sshexec(lscmd)
+	if err != nil {
+		return err
+	}
+	for _, file := range strings.Fields(out) {
+		if !expired(strings.TrimSuffix(file, imageSuffix), "-", "-") {
+			continue
+		}
+		if _, err := sshexec("rm -f " + quote(path.Join(dir, file))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// expired returns true if archive {name}{infix}{unix time}{sep}{retention}
+// is older than its retention.
+func expired(archive, infix, sep string) bool {
+	at, retention, ok := archived(archive, infix, sep)
+	return ok && time.Since(at) >= retention
+}
+
+// archived returns when archive {name}{infix}{unix time}{sep}{retention} was
+// archived and its retention, false if it is not such an archive.
+func archived(archive, infix, sep string) (time.Time, time.Duration, bool) {
+	i := strings.LastIndex(archive, sep)
+	if i < 0 {
+		return time.Time{}, 0, false
+	}
+	retention, err := time.ParseDuration(archive[i+len(sep):])
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+
+	j := strings.LastIndex(archive[:i], infix)
+	if j < 0 {
+		return time.Time{}, 0, false
+	}
+	sec, err := strconv.ParseInt(archive[j+len(infix):i], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+
+	return time.Unix(sec, 0), retention, true
+}
+
+// quote quotes s for the host shell
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
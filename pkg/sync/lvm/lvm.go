@@ -7,10 +7,27 @@ import (
 	"fmt"
 	"os/exec"
 	"strings"
+
+	"github.com/ctriple/drbd/pkg/defs"
 )
 
+type LV struct {
+	Name string
+	Tags []string
+}
+
+// Tagged returns true if lv has tag
+func (lv LV) Tagged(tag string) bool {
+	for _, t := range lv.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
 func Create(vg, name string, sizeMb string) error {
-	sshcmd := []string{"lvcreate", "--name", name, "--size", sizeMb, vg}
+	sshcmd := []string{"lvcreate", "--name", name, "--size", sizeMb, "--addtag", defs.DrbdDiskTag, vg}
 
 	if _, err := sshexec(strings.Join(sshcmd, " ")); err != nil {
 		return err
@@ -19,10 +36,19 @@ func Create(vg, name string, sizeMb string) error {
 	return nil
 }
 
-func Remove(diskPath string) error {
-	// No disk to remove
+// Exists returns true if the disk exists
+func Exists(diskPath string) bool {
 	lscmd := []string{"stat", diskPath}
 	if _, err := sshexec(strings.Join(lscmd, " ")); err != nil {
+		return false
+	}
+
+	return true
+}
+
+func Remove(diskPath string) error {
+	// No disk to remove
+	if !Exists(diskPath) {
 		return nil
 	}
 
@@ -34,6 +60,59 @@ func Remove(diskPath string) error {
 	return nil
 }
 
+// List returns all logical volumes of vg
+func List(vg string) ([]LV, error) {
+	lscmd := []string{"lvs", "--noheadings", "--options", "lv_name,lv_tags", vg}
+
+	out, err := sshexec(strings.Join(lscmd, " "))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseLVs(out), nil
+}
+
+func parseLVs(out string) []LV {
+	var lvs []LV
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		lv := LV{Name: fields[0]}
+		if len(fields) > 1 {
+			lv.Tags = strings.Split(fields[1], ",")
+		}
+		lvs = append(lvs, lv)
+	}
+	return lvs
+}
+
+// Snapshot creates a copy-on-write snapshot called name of the origin disk,
+// the snapshot disk pattern is the same as origin: /dev/{vg}/{name}
+func Snapshot(origin, name string) error {
+	sshcmd := []string{"lvcreate", "--snapshot", "--extents", "10%ORIGIN", "--name", name, origin}
+
+	if _, err := sshexec(strings.Join(sshcmd, " ")); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Copy does a block level copy of disk src onto disk dst, dst must not be
+// smaller than src.
+func Copy(src, dst string) error {
+	ddcmd := []string{"dd", "if=" + src, "of=" + dst, "bs=1M", "oflag=direct", "conv=fsync"}
+
+	if _, err := sshexec(strings.Join(ddcmd, " ")); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // FIXME: This is a technical compromise
 //
 // Since lvm utility needs to access host's /sys and /proc, which makes it not
@@ -4,10 +4,12 @@
 package lvm
 
 import (
+	"fmt"
 	"os/exec"
 	"path"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ctriple/drbd/pkg/defs"
 )
@@ -52,6 +54,63 @@ func Test_show_disk_info(t *testing.T) {
 	t.Log(out)
 }
 
+func TestParseLVs(t *testing.T) {
+	out := `  default-pvc1 ctriple_drbd
+  root
+  swap         backup,other
+`
+	lvs := parseLVs(out)
+	if len(lvs) != 3 {
+		t.Fatalf("unexpected lvs: %+v", lvs)
+	}
+	if !lvs[0].Tagged(defs.DrbdDiskTag) || lvs[1].Tagged(defs.DrbdDiskTag) || len(lvs[2].Tags) != 2 {
+		t.Fatalf("unexpected lv tags: %+v", lvs)
+	}
+}
+
+func TestArchived(t *testing.T) {
+	at, retention, ok := archived("default-pvc1_archived_1546300800_168h0m0s", archivedInfix, "_")
+	if !ok || at.Unix() != 1546300800 || retention != 168*time.Hour {
+		t.Fatalf("unexpected archived disk: %v %v %v", at, retention, ok)
+	}
+	at, retention, ok = archived("default-pvc1-1546300800-1h0m0s", "-", "-")
+	if !ok || at.Unix() != 1546300800 || retention != time.Hour {
+		t.Fatalf("unexpected archived image: %v %v %v", at, retention, ok)
+	}
+
+	for _, name := range []string{"default-pvc1", "default-pvc1_archived_1546300800", "default-pvc1_archived__1h", "default-pvc1_archived_x_1h"} {
+		if _, _, ok := archived(name, archivedInfix, "_"); ok {
+			t.Fatalf("%s is not archived", name)
+		}
+	}
+
+	if !expired("default-pvc1_archived_1546300800_1h", archivedInfix, "_") {
+		t.Fatal("archive past its retention not expired")
+	}
+	if expired(fmt.Sprintf("default-pvc1_archived_%d_1h", time.Now().Unix()), archivedInfix, "_") {
+		t.Fatal("archive within its retention expired")
+	}
+}
+
+func TestValidArchiveDir(t *testing.T) {
+	for _, dir := range []string{"/var/lib/ctriple-drbd/archive", "/data/v1.2_x"} {
+		if err := ValidArchiveDir(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dir := range []string{"", "archive", "/tmp/a b", "/tmp/$(reboot)", "/tmp/a;rm", "/tmp/'a'"} {
+		if err := ValidArchiveDir(dir); err == nil {
+			t.Fatalf("%q accepted", dir)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if q := quote("/a/it's"); q != `'/a/it'\''s'` {
+		t.Fatalf("unexpected quoting: %s", q)
+	}
+}
+
 func TestRemove(t *testing.T) {
 	if skip {
 		t.Skipf("lvm prerequisite does not meet!")
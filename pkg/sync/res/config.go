@@ -0,0 +1,286 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package res
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/ctriple/drbd/pkg/defs"
+	"github.com/ctriple/drbd/pkg/drbdadm"
+)
+
+// Config is the resource file of a resource, as generated by New
+type Config struct {
+	Name  string
+	Nodes []Node
+
+	// Nodes connect either by the address of their on section in a full
+	// mesh of Mesh hosts, or by the paths of Connections.
+	Mesh        []string
+	Connections []Connection
+
+	// Sections keyed by DRBD option name
+	Options  map[string]string
+	Net      map[string]string
+	Disk     map[string]string
+	Handlers map[string]string
+}
+
+// Node is the on section of a host
+type Node struct {
+	Name     string
+	ID       int
+	Device   string
+	Disk     string // "none" for diskless
+	MetaDisk string
+	Address  string // empty if connecting by paths
+}
+
+// Connection between two hosts over one or more paths
+type Connection struct {
+	Paths []Path
+}
+
+// Path is the two ends of a network route between hosts
+type Path []Endpoint
+
+type Endpoint struct {
+	Host    string
+	Address string
+}
+
+const resTemplate = `#
+# AUTO-GENERATED BY ctriple.cn/drbd
+#           DO NOT EDIT
+#
+resource {{.Name}} {
+{{- range .Nodes}}
+
+  on {{.Name}} {
+    node-id   {{.ID}};
+    device    {{.Device}};
+    disk      {{.Disk}};
+{{- if .MetaDisk}}
+    meta-disk {{.MetaDisk}};
+{{- end}}
+{{- if .Address}}
+    address   {{.Address}};
+{{- end}}
+  }
+{{- end}}
+{{- template "section" (section "options" .Options)}}
+{{- if .Mesh}}
+
+  connection-mesh {
+    hosts {{join .Mesh}};
+  }
+{{- end}}
+{{- range .Connections}}
+
+  connection {
+{{- range .Paths}}
+    path {
+{{- range .}}
+      host {{.Host}} address {{.Address}};
+{{- end}}
+    }
+{{- end}}
+  }
+{{- end}}
+{{- template "section" (section "net" .Net)}}
+{{- template "section" (section "handlers" .Handlers)}}
+{{- template "section" (section "disk" .Disk)}}
+}
+{{- define "section"}}
+{{- if .Options}}
+
+  {{.Name}} {
+{{- range $k, $v := .Options}}
+    {{$k}} {{quote $v}};
+{{- end}}
+  }
+{{- end}}
+{{- end}}
+`
+
+var resTmpl = template.Must(template.New("res").Funcs(template.FuncMap{
+	"join":  func(list []string) string { return strings.Join(list, " ") },
+	"quote": quote,
+	"section": func(name string, opts map[string]string) interface{} {
+		return struct {
+			Name    string
+			Options map[string]string
+		}{name, opts}
+	},
+}).Parse(resTemplate))
+
+// word is what DRBD takes for a value without quotes
+var word = regexp.MustCompile(`^[a-zA-Z0-9_./:-]+$`)
+
+// quote quotes option values which are not a single word, such as handler
+// commands.
+func quote(value string) string {
+	if word.MatchString(value) {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+// validate makes sure DRBD accepts a resource file before it is used, var
+// for testing without DRBD
+var validate = drbdadm.Dump
+
+// NewConfig returns the resource file of resName, the diskless hosts take part
+// in the resource without backing disk, such as quorum tiebreakers.
+func NewConfig(resName, disk string, hosts, ips, diskless []string, opts Options) Config {
+	port := Port(resName)
+	dev := fmt.Sprintf(devDrbdFmt, Minor(resName))
+
+	c := Config{
+		Name:     resName,
+		Options:  copyOptions(opts.Resource),
+		Net:      copyOptions(opts.Net),
+		Disk:     copyOptions(opts.Disk),
+		Handlers: map[string]string{},
+	}
+
+	c.Net["fencing"] = "resource-only"
+	if opts.SharedSecret != "" {
+		c.Net["cram-hmac-alg"] = defs.PeerAuthAlg
+		c.Net["shared-secret"] = opts.SharedSecret
+	}
+	c.Handlers["fence-peer"] = defs.FlexDriverExec + " fence-peer"
+	c.Handlers["unfence-peer"] = defs.FlexDriverExec + " unfence-peer"
+	c.Handlers["split-brain"] = defs.FlexDriverExec + " split-brain"
+
+	var paths [][]string
+	for i, h := range hosts {
+		var addrs []string
+		for _, ip := range SplitPaths(ips[i]) {
+			addrs = append(addrs, address(ip, port))
+		}
+		paths = append(paths, addrs)
+
+		n := Node{
+			Name:     h,
+			ID:       i,
+			Device:   dev,
+			Disk:     disk,
+			MetaDisk: "internal",
+		}
+		if Contains(diskless, h) {
+			n.Disk, n.MetaDisk = "none", ""
+		}
+		c.Nodes = append(c.Nodes, n)
+	}
+
+	c.Connections = connections(hosts, paths)
+	if c.Connections == nil {
+		for i := range c.Nodes {
+			c.Nodes[i].Address = paths[i][0]
+		}
+		c.Mesh = append([]string{}, hosts...)
+	}
+
+	return c
+}
+
+func copyOptions(opts map[string]string) map[string]string {
+	c := map[string]string{}
+	for k, v := range opts {
+		c[k] = v
+	}
+	return c
+}
+
+// address returns ip and port as DRBD wants, IPv6 addresses need the family
+// and brackets.
+func address(ip string, port int) string {
+	parsed := net.ParseIP(ip)
+	switch {
+	case parsed == nil:
+		return fmt.Sprintf("%s:%d", ip, port)
+	case parsed.To4() == nil:
+		return fmt.Sprintf("ipv6 [%s]:%d", parsed, port)
+	default:
+		return fmt.Sprintf("%s:%d", parsed, port)
+	}
+}
+
+// connections returns the connections of a full mesh with a path over each
+// network all hosts have an address on, nil if there is only one path. Then
+// the hosts connect by their own address.
+func connections(hosts []string, paths [][]string) []Connection {
+	n := 0
+	for i := range hosts {
+		if i == 0 || len(paths[i]) < n {
+			n = len(paths[i])
+		}
+	}
+	if n < 2 {
+		return nil
+	}
+
+	var conns []Connection
+	for i := range hosts {
+		for j := i + 1; j < len(hosts); j++ {
+			var c Connection
+			for p := 0; p < n; p++ {
+				c.Paths = append(c.Paths, Path{
+					{Host: hosts[i], Address: paths[i][p]},
+					{Host: hosts[j], Address: paths[j][p]},
+				})
+			}
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
+
+// Render writes the resource file of c to w
+func (c Config) Render(w io.Writer) error {
+	return resTmpl.Execute(w, c)
+}
+
+// Write replaces the resource file of c. The new file is validated by DRBD
+// first and then moved in place, so a running resource never sees a partial
+// or invalid file. Only root may read it, it has the shared secret.
+func (c Config) Write() error {
+	resFile := path.Join(resOutDir, c.Name+".res")
+	tmpFile := resFile + ".tmp"
+
+	f, err := os.OpenFile(tmpFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile)
+
+	err = f.Chmod(0600)
+	if err == nil {
+		err = c.Render(f)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := validate(tmpFile, c.Name); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, resFile)
+}
@@ -0,0 +1,137 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package res
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden resource files")
+
+// goldenCases are rendered into the golden files in testdata/golden
+var goldenCases = []struct {
+	golden   string
+	ips      []string
+	diskless []string
+	opts     func(Options)
+}{
+	{
+		golden: "mesh.res",
+		ips:    ips,
+	},
+	{
+		golden:   "diskless.res",
+		ips:      ips,
+		diskless: hosts[2:],
+		opts: func(o Options) {
+			o.Set("protocol", "A")
+			o.Set("al-extents", "6007")
+			o.Set("on-io-error", "detach")
+			o.Quorum(len(hosts))
+		},
+	},
+	{
+		golden: "paths.res",
+		ips:    []string{"10.1.0.11;fd00:2::11", "10.1.0.12;fd00:2::12", "10.1.0.13;fd00:2::13"},
+		opts: func(o Options) {
+			o.Set("tls", "yes")
+		},
+	},
+}
+
+// goldenConfig returns the config of golden case i
+func goldenConfig(i int) Config {
+	c := goldenCases[i]
+	opts := DefaultOptions()
+	opts.SharedSecret = "0123456789abcdef"
+	if c.opts != nil {
+		c.opts(opts)
+	}
+	return NewConfig(resName, disk, hosts, c.ips, c.diskless, opts)
+}
+
+// Resource files generated for the cases are compared with the golden files,
+// run go test -update to regenerate them.
+func TestGolden(t *testing.T) {
+	for i, c := range goldenCases {
+		var out bytes.Buffer
+		if err := goldenConfig(i).Render(&out); err != nil {
+			t.Fatal(err)
+		}
+
+		golden := path.Join("testdata", "golden", c.golden)
+		if *update {
+			if err := ioutil.WriteFile(golden, out.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expect, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), expect) {
+			t.Errorf("%s: unexpected resource file:\n%s", c.golden, out.String())
+		}
+	}
+}
+
+func TestConnections(t *testing.T) {
+	hosts := []string{"node1", "node2", "node3"}
+	paths := [][]string{
+		{"10.1.0.1:7000", "10.2.0.1:7000"},
+		{"10.1.0.2:7000", "10.2.0.2:7000"},
+		{"10.1.0.3:7000", "10.2.0.3:7000", "10.3.0.3:7000"},
+	}
+
+	conns := connections(hosts, paths)
+	if len(conns) != 3 {
+		t.Fatalf("expect full mesh of 3 connections: %+v", conns)
+	}
+	for _, c := range conns {
+		if len(c.Paths) != 2 {
+			t.Fatalf("expect 2 paths all hosts have: %+v", c)
+		}
+	}
+	if p := conns[2].Paths[1]; p[0].Host != "node2" || p[0].Address != "10.2.0.2:7000" || p[1].Address != "10.2.0.3:7000" {
+		t.Fatalf("unexpected path: %+v", p)
+	}
+
+	if conns := connections(hosts[:1], paths[:1]); conns != nil {
+		t.Fatalf("expect no connections for one host: %+v", conns)
+	}
+	paths[0] = paths[0][:1]
+	if conns := connections(hosts, paths); conns != nil {
+		t.Fatalf("expect connection mesh for single path: %+v", conns)
+	}
+}
+
+func TestAddress(t *testing.T) {
+	for ip, expect := range map[string]string{
+		"172.25.33.11":       "172.25.33.11:7000",
+		"fd00::11":           "ipv6 [fd00::11]:7000",
+		"fd00:0:0:0:0:0:0:1": "ipv6 [fd00::1]:7000",
+		"::ffff:172.25.33.1": "172.25.33.1:7000",
+	} {
+		if addr := address(ip, 7000); addr != expect {
+			t.Fatalf("%s: expect %q got %q", ip, expect, addr)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for value, expect := range map[string]string{
+		"resource-only":    "resource-only",
+		"/usr/bin/handler": "/usr/bin/handler",
+		"handler fence":    `"handler fence"`,
+		`say "hi"`:         `"say \"hi\""`,
+	} {
+		if q := quote(value); q != expect {
+			t.Fatalf("%s: expect %s got %s", value, expect, q)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package res
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Diff returns what differs between resource files a and b, such as "net
+// protocol" or "on node1 address". Values are left out, they may be secret.
+func Diff(a, b Config) []string {
+	var diffs []string
+
+	if a.Name != b.Name {
+		diffs = append(diffs, "resource name")
+	}
+
+	nodes := map[string]bool{}
+	for _, n := range append(append([]Node{}, a.Nodes...), b.Nodes...) {
+		if nodes[n.Name] {
+			continue
+		}
+		nodes[n.Name] = true
+
+		an, aok := a.Node(n.Name)
+		bn, bok := b.Node(n.Name)
+		if !aok || !bok {
+			diffs = append(diffs, "on "+n.Name)
+			continue
+		}
+		for _, f := range []struct {
+			name string
+			a, b string
+		}{
+			{"device", an.Device, bn.Device},
+			{"disk", an.Disk, bn.Disk},
+			{"meta-disk", an.MetaDisk, bn.MetaDisk},
+			{"address", an.Address, bn.Address},
+		} {
+			if f.a != f.b {
+				diffs = append(diffs, "on "+n.Name+" "+f.name)
+			}
+		}
+		if an.ID != bn.ID {
+			diffs = append(diffs, "on "+n.Name+" node-id")
+		}
+	}
+
+	if !reflect.DeepEqual(a.Mesh, b.Mesh) {
+		diffs = append(diffs, "connection-mesh")
+	}
+	if !reflect.DeepEqual(a.Connections, b.Connections) {
+		diffs = append(diffs, "connection")
+	}
+
+	for _, section := range []struct {
+		name string
+		a, b map[string]string
+	}{
+		{"options", a.Options, b.Options},
+		{"net", a.Net, b.Net},
+		{"handlers", a.Handlers, b.Handlers},
+		{"disk", a.Disk, b.Disk},
+	} {
+		var keys []string
+		for k, v := range section.a {
+			if bv, ok := section.b[k]; !ok || bv != v {
+				keys = append(keys, k)
+			}
+		}
+		for k := range section.b {
+			if _, ok := section.a[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			diffs = append(diffs, section.name+" "+k)
+		}
+	}
+
+	return diffs
+}
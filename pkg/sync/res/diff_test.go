@@ -0,0 +1,37 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package res
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	if diffs := Diff(goldenConfig(1), goldenConfig(1)); len(diffs) != 0 {
+		t.Fatalf("expect no differences: %v", diffs)
+	}
+
+	// Edited by hand
+	c := goldenConfig(1)
+	c.Net["protocol"] = "C"
+	c.Net["ping-timeout"] = "10"
+	c.Net["shared-secret"] = "guessed"
+	delete(c.Disk, "al-extents")
+	c.Nodes[0].Address = "172.25.33.99:7072"
+	c.Nodes = c.Nodes[:2]
+
+	diffs := strings.Join(Diff(c, goldenConfig(1)), ",")
+	expect := "on node1.example.com address,on node3.example.com,net ping-timeout,net protocol,net shared-secret,disk al-extents"
+	if diffs != expect {
+		t.Fatalf("unexpected differences: %s", diffs)
+	}
+	if strings.Contains(diffs, "guessed") {
+		t.Fatal("expect no values in differences")
+	}
+
+	if diffs := strings.Join(Diff(goldenConfig(0), goldenConfig(2)), ","); !strings.Contains(diffs, "connection-mesh,connection,") {
+		t.Fatalf("unexpected differences: %s", diffs)
+	}
+}
@@ -0,0 +1,198 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package res
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Options are DRBD tunables rendered into the net, disk and options sections
+// of a resource file, keyed by DRBD option name.
+type Options struct {
+	Net      map[string]string
+	Disk     map[string]string
+	Resource map[string]string
+
+	// Peer authentication, never encoded by Env since it comes from a
+	// Secret
+	SharedSecret string
+}
+
+// validator returns error if value is not acceptable for a DRBD option
+type validator func(value string) error
+
+// Allow-list of tunable options, anything else is refused since it is
+// rendered into the resource file verbatim.
+var (
+	netOptions = map[string]validator{
+		"protocol":    oneOf("A", "B", "C"),
+		"csums-alg":   oneOf(hashAlgs...),
+		"verify-alg":  oneOf(hashAlgs...),
+		"max-buffers": numRange(32, 131072),
+		"sndbuf-size": numRange(0, 10<<20),
+		"tls":         oneOf("yes", "no"),
+
+		"after-sb-0pri": oneOf("disconnect", "discard-younger-primary", "discard-older-primary",
+			"discard-zero-changes", "discard-least-changes", "discard-local", "discard-remote"),
+		"after-sb-1pri": oneOf("disconnect", "consensus", "violently-as0p", "discard-secondary"),
+		"after-sb-2pri": oneOf("disconnect", "violently-as0p"),
+	}
+
+	diskOptions = map[string]validator{
+		"c-plan-ahead":  numRange(0, 300),
+		"c-fill-target": numRange(0, 1<<20),
+		"al-extents":    numRange(67, 65534),
+		"on-io-error":   oneOf("pass_on", "call-local-io-error", "detach"),
+		"disk-flushes":  oneOf("yes", "no"),
+	}
+
+	resourceOptions = map[string]validator{
+		"quorum":       oneOf("off", "majority", "all"),
+		"on-no-quorum": oneOf("io-error", "suspend-io"),
+	}
+
+	hashAlgs = []string{"crc32c", "md5", "sha1", "sha256"}
+)
+
+// DefaultOptions returns the options every resource starts with. Split brain
+// is resolved automatically only when at most one side has changed data.
+func DefaultOptions() Options {
+	return Options{
+		Net: map[string]string{
+			"protocol":      "C",
+			"csums-alg":     "crc32c",
+			"verify-alg":    "crc32c",
+			"after-sb-0pri": "discard-zero-changes",
+			"after-sb-1pri": "discard-secondary",
+			"after-sb-2pri": "disconnect",
+		},
+		Disk:     map[string]string{},
+		Resource: map[string]string{},
+	}
+}
+
+// Quorum enables quorum majority for resources with at least 3 nodes
+// (including diskless tiebreakers), unless the quorum options are already set.
+// Losing quorum fails io by default.
+func (o Options) Quorum(nodes int) {
+	if nodes < 3 {
+		return
+	}
+	if _, ok := o.Resource["quorum"]; !ok {
+		o.Resource["quorum"] = "majority"
+	}
+	if _, ok := o.Resource["on-no-quorum"]; !ok {
+		o.Resource["on-no-quorum"] = "io-error"
+	}
+}
+
+// TLS returns true if replication traffic is encrypted by the tls transport
+func (o Options) TLS() bool {
+	return o.Net["tls"] == "yes"
+}
+
+// Set validates and records option key, known reports whether key is a DRBD
+// option on the allow-list at all.
+func (o Options) Set(key, value string) (known bool, err error) {
+	for _, section := range []struct {
+		allow map[string]validator
+		opts  map[string]string
+	}{
+		{netOptions, o.Net},
+		{diskOptions, o.Disk},
+		{resourceOptions, o.Resource},
+	} {
+		valid, ok := section.allow[key]
+		if !ok {
+			continue
+		}
+		if err := valid(value); err != nil {
+			return true, fmt.Errorf("%s: %v", key, err)
+		}
+		section.opts[key] = value
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// Env encodes options as sync job environment values
+func (o Options) Env() (net, disk, resource string) {
+	return encode(o.Net), encode(o.Disk), encode(o.Resource)
+}
+
+// ParseEnv decodes and validates options encoded by Options.Env
+func ParseEnv(net, disk, resource string) (Options, error) {
+	o := DefaultOptions()
+
+	for _, env := range []string{net, disk, resource} {
+		for _, pair := range strings.Split(env, ",") {
+			if pair == "" {
+				continue
+			}
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) < 2 {
+				return o, fmt.Errorf("malformed option: %q", pair)
+			}
+			if known, err := o.Set(kv[0], kv[1]); !known {
+				return o, fmt.Errorf("unknown option: %q", kv[0])
+			} else if err != nil {
+				return o, err
+			}
+		}
+	}
+
+	return o, nil
+}
+
+func encode(opts map[string]string) string {
+	var pairs []string
+	for k, v := range opts {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+func oneOf(values ...string) validator {
+	return func(value string) error {
+		for _, v := range values {
+			if v == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q must be one of %v", value, values)
+	}
+}
+
+// numRange accepts integer within [min, max], with an optional k/M/G unit
+// suffix as drbdadm does.
+func numRange(min, max int64) validator {
+	return func(value string) error {
+		num, unit := value, int64(1)
+		if n := len(value); n > 0 {
+			switch value[n-1] {
+			case 'k', 'K':
+				num, unit = value[:n-1], 1<<10
+			case 'm', 'M':
+				num, unit = value[:n-1], 1<<20
+			case 'g', 'G':
+				num, unit = value[:n-1], 1<<30
+			}
+		}
+
+		n, err := strconv.ParseInt(num, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		if n*unit < min || n*unit > max {
+			return fmt.Errorf("%q out of range [%d, %d]", value, min, max)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,83 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package res
+
+import (
+	"testing"
+)
+
+func TestOptionsSet(t *testing.T) {
+	cases := []struct {
+		key, value string
+		known, ok  bool
+	}{
+		{"protocol", "A", true, true},
+		{"protocol", "D", true, false},
+		{"verify-alg", "sha256", true, true},
+		{"max-buffers", "8000", true, true},
+		{"max-buffers", "8", true, false},
+		{"sndbuf-size", "2M", true, true},
+		{"sndbuf-size", "20M", true, false},
+		{"c-fill-target", "1M", true, true},
+		{"al-extents", "x", true, false},
+		{"on-io-error", "detach", true, true},
+		{"disk-flushes", "no", true, true},
+		{"after-sb-1pri", "discard-secondary", true, true},
+		{"after-sb-2pri", "discard-local", true, false},
+		{"quorum", "majority", true, true},
+		{"on-no-quorum", "suspend-io", true, true},
+		{"on-no-quorum", "freeze", true, false},
+		{"fencing", "resource-only", false, true},
+	}
+
+	for _, c := range cases {
+		opts := DefaultOptions()
+		known, err := opts.Set(c.key, c.value)
+		if known != c.known || (err == nil) != c.ok {
+			t.Errorf("Set(%q, %q) = %v, %v", c.key, c.value, known, err)
+		}
+	}
+}
+
+func TestOptionsEnv(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Set("protocol", "B")
+	opts.Set("c-plan-ahead", "20")
+	opts.Set("disk-flushes", "no")
+
+	opts.Quorum(3)
+
+	net, disk, resource := opts.Env()
+	if net != "after-sb-0pri=discard-zero-changes,after-sb-1pri=discard-secondary,after-sb-2pri=disconnect,csums-alg=crc32c,protocol=B,verify-alg=crc32c" ||
+		disk != "c-plan-ahead=20,disk-flushes=no" ||
+		resource != "on-no-quorum=io-error,quorum=majority" {
+		t.Fatal(net, disk, resource)
+	}
+
+	parsed, err := ParseEnv(net, disk, resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Net["protocol"] != "B" || parsed.Disk["c-plan-ahead"] != "20" || parsed.Resource["quorum"] != "majority" {
+		t.Fatal(parsed)
+	}
+
+	if _, err := ParseEnv("fencing=resource-only", "", ""); err == nil {
+		t.Fatal("expect error for option not on allow-list")
+	}
+}
+
+func TestOptionsQuorum(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Quorum(2)
+	if len(opts.Resource) != 0 {
+		t.Fatal("2 nodes should not have quorum", opts.Resource)
+	}
+
+	opts.Set("on-no-quorum", "suspend-io")
+	opts.Quorum(3)
+	if opts.Resource["quorum"] != "majority" || opts.Resource["on-no-quorum"] != "suspend-io" {
+		t.Fatal(opts.Resource)
+	}
+}
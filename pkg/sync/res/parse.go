@@ -0,0 +1,362 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package res
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// statement of a DRBD config file, a keyword with its arguments ended by ";",
+// or a section whose statements are enclosed in braces.
+type statement struct {
+	words []string
+	block []statement
+	// section, even if empty
+	section bool
+	line    int
+}
+
+type token struct {
+	text string
+	// quoted string, never a delimiter
+	quoted bool
+	line   int
+}
+
+// tokenize splits a DRBD config file into words, quoted strings and the
+// delimiters "{", "}" and ";". Comments run from "#" to the end of line.
+func tokenize(data string) ([]token, error) {
+	var tokens []token
+	line := 1
+
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case c == '{' || c == '}' || c == ';':
+			tokens = append(tokens, token{text: string(c), line: line})
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(data) && data[j] != '"'; j++ {
+				if data[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(data) {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			s, err := strconv.Unquote(data[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			tokens = append(tokens, token{text: s, quoted: true, line: line})
+			line += strings.Count(data[i:j+1], "\n")
+			i = j + 1
+		default:
+			j := i
+			for ; j < len(data) && !strings.ContainsRune(" \t\r\n{};#\"", rune(data[j])); j++ {
+			}
+			tokens = append(tokens, token{text: data[i:j], line: line})
+			i = j
+		}
+	}
+
+	return tokens, nil
+}
+
+// parseBlock returns the statements up to the "}" closing the block, or up to
+// the end if top.
+func parseBlock(tokens []token, top bool) ([]statement, []token, error) {
+	var block []statement
+	var words []string
+	line := 0
+
+	for len(tokens) > 0 {
+		t := tokens[0]
+		tokens = tokens[1:]
+		if len(words) == 0 {
+			line = t.line
+		}
+
+		switch {
+		case t.quoted:
+			words = append(words, t.text)
+		case t.text == ";":
+			// Empty statement, such as after a section
+			if len(words) > 0 {
+				block = append(block, statement{words: words, line: line})
+				words = nil
+			}
+		case t.text == "{":
+			if len(words) == 0 {
+				return nil, nil, fmt.Errorf("line %d: section without name", t.line)
+			}
+			sub, rest, err := parseBlock(tokens, false)
+			if err != nil {
+				return nil, nil, err
+			}
+			block = append(block, statement{words: words, block: sub, section: true, line: line})
+			words, tokens = nil, rest
+		case t.text == "}":
+			if top {
+				return nil, nil, fmt.Errorf("line %d: unexpected }", t.line)
+			}
+			if len(words) > 0 {
+				return nil, nil, fmt.Errorf("line %d: missing ; after %s", line, strings.Join(words, " "))
+			}
+			return block, tokens, nil
+		default:
+			words = append(words, t.text)
+		}
+	}
+
+	if !top {
+		return nil, nil, fmt.Errorf("missing }")
+	}
+	if len(words) > 0 {
+		return nil, nil, fmt.Errorf("line %d: missing ; after %s", line, strings.Join(words, " "))
+	}
+	return block, nil, nil
+}
+
+// Parse returns the resources of a DRBD resource file. It understands the
+// sections New writes: on, options, connection-mesh, connection with paths,
+// net, handlers and disk, anything else is an error.
+func Parse(data []byte) ([]Config, error) {
+	tokens, err := tokenize(string(data))
+	if err != nil {
+		return nil, err
+	}
+	top, _, err := parseBlock(tokens, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []Config
+	for _, s := range top {
+		if !s.section || s.words[0] != "resource" || len(s.words) != 2 {
+			return nil, s.unsupported()
+		}
+		c, err := parseResource(s)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, c)
+	}
+
+	return configs, nil
+}
+
+// Load parses the resource file of resName
+func Load(resName string) (Config, error) {
+	data, err := ioutil.ReadFile(path.Join(resOutDir, resName+".res"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	configs, err := Parse(data)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %v", resName, err)
+	}
+	for _, c := range configs {
+		if c.Name == resName {
+			return c, nil
+		}
+	}
+
+	return Config{}, fmt.Errorf("%s: no such resource in resource file", resName)
+}
+
+// Node returns the on section of host
+func (c Config) Node(host string) (Node, bool) {
+	for _, n := range c.Nodes {
+		if n.Name == host {
+			return n, true
+		}
+	}
+	return Node{}, false
+}
+
+func parseResource(r statement) (Config, error) {
+	c := Config{
+		Name:     r.words[1],
+		Options:  map[string]string{},
+		Net:      map[string]string{},
+		Disk:     map[string]string{},
+		Handlers: map[string]string{},
+	}
+
+	for _, s := range r.block {
+		if !s.section {
+			return c, s.unsupported()
+		}
+
+		var err error
+		switch s.words[0] {
+		case "on":
+			var n Node
+			n, err = parseNode(s)
+			c.Nodes = append(c.Nodes, n)
+		case "connection-mesh":
+			c.Mesh, err = parseMesh(s)
+		case "connection":
+			var conn Connection
+			conn, err = parseConnection(s)
+			c.Connections = append(c.Connections, conn)
+		case "options":
+			err = parseOptions(s, c.Options)
+		case "net":
+			err = parseOptions(s, c.Net)
+		case "disk":
+			err = parseOptions(s, c.Disk)
+		case "handlers":
+			err = parseOptions(s, c.Handlers)
+		default:
+			err = s.unsupported()
+		}
+		if err != nil {
+			return c, err
+		}
+	}
+
+	return c, nil
+}
+
+func parseNode(on statement) (Node, error) {
+	if len(on.words) != 2 {
+		return Node{}, on.unsupported()
+	}
+	n := Node{Name: on.words[1]}
+
+	for _, s := range on.block {
+		if s.section || len(s.words) < 2 {
+			return n, s.unsupported()
+		}
+		value := strings.Join(s.words[1:], " ")
+
+		switch s.words[0] {
+		case "node-id":
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				return n, fmt.Errorf("line %d: node-id: %v", s.line, err)
+			}
+			n.ID = id
+		case "device":
+			n.Device = value
+		case "disk":
+			n.Disk = value
+		case "meta-disk":
+			n.MetaDisk = value
+		case "address":
+			n.Address = parseAddress(s.words[1:])
+		default:
+			return n, s.unsupported()
+		}
+	}
+
+	return n, nil
+}
+
+// parseAddress returns address as written by New, which leaves out the
+// default family ipv4.
+func parseAddress(words []string) string {
+	if len(words) == 2 && words[0] == "ipv4" {
+		return words[1]
+	}
+	return strings.Join(words, " ")
+}
+
+func parseMesh(mesh statement) ([]string, error) {
+	var hosts []string
+	for _, s := range mesh.block {
+		if s.section || s.words[0] != "hosts" {
+			return nil, s.unsupported()
+		}
+		hosts = append(hosts, s.words[1:]...)
+	}
+	return hosts, nil
+}
+
+// parseConnection also takes the host statements of a connection without
+// path sections, as its only path.
+func parseConnection(conn statement) (Connection, error) {
+	var c Connection
+	var hosts []statement
+
+	for _, s := range conn.block {
+		switch {
+		case s.section && s.words[0] == "path" && len(s.words) == 1:
+			p, err := parsePath(s.block)
+			if err != nil {
+				return c, err
+			}
+			c.Paths = append(c.Paths, p)
+		case !s.section && s.words[0] == "host":
+			hosts = append(hosts, s)
+		default:
+			return c, s.unsupported()
+		}
+	}
+
+	if len(hosts) > 0 {
+		if len(c.Paths) > 0 {
+			return c, fmt.Errorf("line %d: connection with both host and path", conn.line)
+		}
+		p, err := parsePath(hosts)
+		if err != nil {
+			return c, err
+		}
+		c.Paths = append(c.Paths, p)
+	}
+
+	return c, nil
+}
+
+func parsePath(block []statement) (Path, error) {
+	var p Path
+	for _, s := range block {
+		// host {name} address [{family}] {ip}:{port}
+		if s.section || s.words[0] != "host" || len(s.words) < 4 || s.words[2] != "address" {
+			return nil, s.unsupported()
+		}
+		p = append(p, Endpoint{Host: s.words[1], Address: parseAddress(s.words[3:])})
+	}
+	if len(p) != 2 {
+		return nil, fmt.Errorf("path with %d hosts", len(p))
+	}
+	return p, nil
+}
+
+func parseOptions(section statement, opts map[string]string) error {
+	if len(section.words) != 1 {
+		return section.unsupported()
+	}
+	for _, s := range section.block {
+		if s.section || len(s.words) < 2 {
+			return s.unsupported()
+		}
+		opts[s.words[0]] = strings.Join(s.words[1:], " ")
+	}
+	return nil
+}
+
+func (s statement) unsupported() error {
+	if s.section {
+		return fmt.Errorf("line %d: unsupported section %s", s.line, strings.Join(s.words, " "))
+	}
+	return fmt.Errorf("line %d: unsupported %s", s.line, strings.Join(s.words, " "))
+}
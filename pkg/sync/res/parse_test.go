@@ -0,0 +1,128 @@
+//
+// Copyright (c) Zhou Peng <p@ctriple.cn>
+//
+package res
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// Golden files parse back into the config they were rendered from, which
+// renders the same file again.
+func TestParseGolden(t *testing.T) {
+	for i, c := range goldenCases {
+		data, err := ioutil.ReadFile(path.Join("testdata", "golden", c.golden))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		configs, err := Parse(data)
+		if err != nil {
+			t.Fatalf("%s: %v", c.golden, err)
+		}
+		if len(configs) != 1 {
+			t.Fatalf("%s: expect 1 resource: %+v", c.golden, configs)
+		}
+		if expect := goldenConfig(i); !reflect.DeepEqual(configs[0], expect) {
+			t.Fatalf("%s: parsed\n%+v\nexpect\n%+v", c.golden, configs[0], expect)
+		}
+
+		var out bytes.Buffer
+		if err := configs[0].Render(&out); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("%s: rendered again:\n%s", c.golden, out.String())
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	data := `# written by hand
+resource r0 {
+  on alpha {  # first
+    node-id 0; device /dev/drbd1; disk /dev/vg/r0; meta-disk internal;
+    address ipv4 10.0.0.1:7001;
+  }
+  on bravo {
+    node-id 1;
+    device /dev/drbd1;
+    disk none;
+    address ipv6 [fd00::2]:7001;
+  }
+  connection {
+    host alpha address 10.0.0.1:7001;
+    host bravo address ipv6 [fd00::2]:7001;
+  };
+  net { shared-secret "a secret;with {delimiters}"; protocol C; }
+}
+
+resource r1 {
+  on alpha { node-id 0; }
+}
+`
+	configs, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 2 || configs[0].Name != "r0" || configs[1].Name != "r1" {
+		t.Fatalf("unexpected resources: %+v", configs)
+	}
+
+	c := configs[0]
+	alpha, ok := c.Node("alpha")
+	if !ok || alpha.Disk != "/dev/vg/r0" || alpha.MetaDisk != "internal" || alpha.Address != "10.0.0.1:7001" {
+		t.Fatalf("unexpected node: %+v", alpha)
+	}
+	bravo, ok := c.Node("bravo")
+	if !ok || bravo.ID != 1 || bravo.Disk != "none" || bravo.Address != "ipv6 [fd00::2]:7001" {
+		t.Fatalf("unexpected node: %+v", bravo)
+	}
+	if _, ok := c.Node("charlie"); ok {
+		t.Fatal("expect no node charlie")
+	}
+	if len(c.Connections) != 1 || len(c.Connections[0].Paths) != 1 || c.Connections[0].Paths[0][1].Address != "ipv6 [fd00::2]:7001" {
+		t.Fatalf("unexpected connections: %+v", c.Connections)
+	}
+	if c.Net["shared-secret"] != "a secret;with {delimiters}" || c.Net["protocol"] != "C" {
+		t.Fatalf("unexpected net: %+v", c.Net)
+	}
+
+	for _, bad := range []string{
+		"resource r0 {",
+		"resource r0 { } }",
+		"resource r0 { net { protocol C } }",
+		"resource r0 { volume 0 { device /dev/drbd1; } }",
+		"resource r0 { on alpha { node-id x; } }",
+		"resource r0 { connection { path { host alpha address 10.0.0.1:7001; } } }",
+		"global { usage-count no; }",
+		`resource r0 { net { shared-secret "open; } }`,
+	} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Fatalf("expect error: %s", bad)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	if err := goldenConfig(1).Write(); err != nil {
+		t.Fatal(err)
+	}
+	defer Del(resName)
+
+	c, err := Load(resName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := c.Node(hosts[2]); !ok || n.Disk != "none" {
+		t.Fatalf("unexpected diskless node: %+v", n)
+	}
+
+	if _, err := Load("no-such-res"); err == nil {
+		t.Fatal("expect error for missing resource file")
+	}
+}
@@ -4,44 +4,18 @@
 package res
 
 import (
-	"fmt"
 	"hash/fnv"
-	"html/template"
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 
 	"github.com/ctriple/drbd/pkg/defs"
 )
 
-const resTemplate = `#
-# AUTO-GENERATED BY ctriple.cn/drbd
-#           DO NOT EDIT
-#
-resource {{.ResName}} {
-
-{{range .Nodes}}
-
-  on {{.Name}} {
-    node-id   {{.ID}};
-    device    {{.Device}};
-    disk      {{.Disk}};
-    address   {{.Address}};
-    meta-disk internal;
-  }
-
-{{end}}
-
-  connection-mesh {
-    hosts {{range .Nodes}} {{.Name}}{{end}};
-  }
-
-  net {
-    protocol  C;
-    csums-alg crc32c;
-  }
-
-}
-`
+// First line of all generated resource files
+const resHeader = "#\n# AUTO-GENERATED BY ctriple.cn/drbd\n"
 
 // nr use hash algorithm to map resource name to a range of integer.
 func nr(resName string) int {
@@ -54,58 +28,71 @@ func nr(resName string) int {
 	return int(nr)
 }
 
+// Minor returns the drbd device minor of resName
+func Minor(resName string) int {
+	return nr(resName)
+}
+
+// Port returns the tcp port of resName on all its hosts
+func Port(resName string) int {
+	return defs.DrbdPortMin + nr(resName)
+}
+
 // FIXME: Should have been const, but var is easy for testing
 var (
 	resOutDir  = "/etc/drbd.d"
 	devDrbdFmt = "/dev/drbd%d"
 )
 
-type node struct {
-	ID      int
-	Name    string
-	Device  string
-	Disk    string
-	Address string
+// PathSep separates the replication addresses of a host, one for each path
+const PathSep = ";"
+
+// JoinPaths returns the replication addresses of a host as one
+func JoinPaths(addrs []string) string {
+	return strings.Join(addrs, PathSep)
 }
 
-func New(resName, disk string, hosts, ips []string) error {
-	nr := nr(resName)
-	dev := fmt.Sprintf(devDrbdFmt, nr)
+// SplitPaths returns the replication addresses of a host joined by JoinPaths
+func SplitPaths(addrs string) []string {
+	return strings.Split(addrs, PathSep)
+}
 
-	var nodes []node
-	for i, h := range hosts {
-		addr := fmt.Sprintf("%s:%d", ips[i], defs.DrbdPortMin+nr)
+// New generates the resource file of resName, see NewConfig
+func New(resName, disk string, hosts, ips, diskless []string, opts Options) error {
+	return NewConfig(resName, disk, hosts, ips, diskless, opts).Write()
+}
 
-		n := node{
-			ID:      i,
-			Name:    h,
-			Device:  dev,
-			Disk:    disk,
-			Address: addr,
+// Contains returns true if s is in list, such as a host in the hosts of a
+// resource
+func Contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
 		}
-		nodes = append(nodes, n)
 	}
+	return false
+}
 
-	resFile := path.Join(resOutDir, resName+".res")
-	writer, err := os.OpenFile(resFile, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+// Generated returns names of all resources whose resource file is generated
+// by New.
+func Generated() ([]string, error) {
+	files, err := filepath.Glob(path.Join(resOutDir, "*.res"))
 	if err != nil {
-		return err
-	}
-	defer writer.Close()
-
-	resTmpl := template.Must(template.New(resName).Parse(resTemplate))
-	data := struct {
-		ResName string
-		Nodes   []node
-	}{
-		ResName: resName,
-		Nodes:   nodes,
+		return nil, err
 	}
-	if err := resTmpl.Execute(writer, data); err != nil {
-		return err
+
+	var resNames []string
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(string(data), resHeader) {
+			resNames = append(resNames, strings.TrimSuffix(path.Base(f), ".res"))
+		}
 	}
 
-	return nil
+	return resNames, nil
 }
 
 func Del(resName string) error {
@@ -5,7 +5,9 @@ package res
 
 import (
 	"io/ioutil"
+	"os"
 	"path"
+	"strings"
 	"testing"
 )
 
@@ -28,12 +30,35 @@ var (
 
 func init() {
 	resOutDir = "testdata"
+	validate = func(file, resName string) error { return nil }
 }
 
 func TestNew(t *testing.T) {
-	if err := New(resName, disk, hosts, ips); err != nil {
+	opts := DefaultOptions()
+	opts.Set("protocol", "A")
+	opts.Set("al-extents", "6007")
+	opts.Quorum(len(hosts))
+	opts.SharedSecret = "0123456789abcdef"
+
+	if err := New(resName, disk, hosts, ips, hosts[2:], opts); err != nil {
+		t.Fatal(err)
+	}
+
+	resFile := path.Join(resOutDir, resName+".res")
+	fi, err := os.Stat(resFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected resource file mode: %v", fi.Mode())
+	}
+	data, err := ioutil.ReadFile(resFile)
+	if err != nil {
 		t.Fatal(err)
 	}
+	if !strings.Contains(string(data), "shared-secret 0123456789abcdef;") {
+		t.Fatal("expect shared-secret in net section")
+	}
 }
 
 // This pseudo testcase is used to display the generated resource file before it
@@ -47,6 +72,16 @@ func Test_show_res(t *testing.T) {
 	t.Log(string(data))
 }
 
+func TestGenerated(t *testing.T) {
+	resNames, err := Generated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resNames) != 1 || resNames[0] != resName {
+		t.Fatalf("unexpected generated resources: %v", resNames)
+	}
+}
+
 func TestDel(t *testing.T) {
 	if err := Del(resName); err != nil {
 		t.Fatal(err)
@@ -0,0 +1,61 @@
+#
+# AUTO-GENERATED BY ctriple.cn/drbd
+#           DO NOT EDIT
+#
+resource res-testing {
+
+  on node1.example.com {
+    node-id   0;
+    device    /dev/drbd72;
+    disk      /dev/lvm/res-testing;
+    meta-disk internal;
+    address   172.25.33.11:7072;
+  }
+
+  on node2.example.com {
+    node-id   1;
+    device    /dev/drbd72;
+    disk      /dev/lvm/res-testing;
+    meta-disk internal;
+    address   172.25.33.12:7072;
+  }
+
+  on node3.example.com {
+    node-id   2;
+    device    /dev/drbd72;
+    disk      none;
+    address   172.25.33.13:7072;
+  }
+
+  options {
+    on-no-quorum io-error;
+    quorum majority;
+  }
+
+  connection-mesh {
+    hosts node1.example.com node2.example.com node3.example.com;
+  }
+
+  net {
+    after-sb-0pri discard-zero-changes;
+    after-sb-1pri discard-secondary;
+    after-sb-2pri disconnect;
+    cram-hmac-alg sha256;
+    csums-alg crc32c;
+    fencing resource-only;
+    protocol A;
+    shared-secret 0123456789abcdef;
+    verify-alg crc32c;
+  }
+
+  handlers {
+    fence-peer "/usr/libexec/kubernetes/kubelet-plugins/volume/exec/ctriple.cn~drbd/drbd fence-peer";
+    split-brain "/usr/libexec/kubernetes/kubelet-plugins/volume/exec/ctriple.cn~drbd/drbd split-brain";
+    unfence-peer "/usr/libexec/kubernetes/kubelet-plugins/volume/exec/ctriple.cn~drbd/drbd unfence-peer";
+  }
+
+  disk {
+    al-extents 6007;
+    on-io-error detach;
+  }
+}
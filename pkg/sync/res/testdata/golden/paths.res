@@ -0,0 +1,79 @@
+#
+# AUTO-GENERATED BY ctriple.cn/drbd
+#           DO NOT EDIT
+#
+resource res-testing {
+
+  on node1.example.com {
+    node-id   0;
+    device    /dev/drbd72;
+    disk      /dev/lvm/res-testing;
+    meta-disk internal;
+  }
+
+  on node2.example.com {
+    node-id   1;
+    device    /dev/drbd72;
+    disk      /dev/lvm/res-testing;
+    meta-disk internal;
+  }
+
+  on node3.example.com {
+    node-id   2;
+    device    /dev/drbd72;
+    disk      /dev/lvm/res-testing;
+    meta-disk internal;
+  }
+
+  connection {
+    path {
+      host node1.example.com address 10.1.0.11:7072;
+      host node2.example.com address 10.1.0.12:7072;
+    }
+    path {
+      host node1.example.com address ipv6 [fd00:2::11]:7072;
+      host node2.example.com address ipv6 [fd00:2::12]:7072;
+    }
+  }
+
+  connection {
+    path {
+      host node1.example.com address 10.1.0.11:7072;
+      host node3.example.com address 10.1.0.13:7072;
+    }
+    path {
+      host node1.example.com address ipv6 [fd00:2::11]:7072;
+      host node3.example.com address ipv6 [fd00:2::13]:7072;
+    }
+  }
+
+  connection {
+    path {
+      host node2.example.com address 10.1.0.12:7072;
+      host node3.example.com address 10.1.0.13:7072;
+    }
+    path {
+      host node2.example.com address ipv6 [fd00:2::12]:7072;
+      host node3.example.com address ipv6 [fd00:2::13]:7072;
+    }
+  }
+
+  net {
+    after-sb-0pri discard-zero-changes;
+    after-sb-1pri discard-secondary;
+    after-sb-2pri disconnect;
+    cram-hmac-alg sha256;
+    csums-alg crc32c;
+    fencing resource-only;
+    protocol C;
+    shared-secret 0123456789abcdef;
+    tls yes;
+    verify-alg crc32c;
+  }
+
+  handlers {
+    fence-peer "/usr/libexec/kubernetes/kubelet-plugins/volume/exec/ctriple.cn~drbd/drbd fence-peer";
+    split-brain "/usr/libexec/kubernetes/kubelet-plugins/volume/exec/ctriple.cn~drbd/drbd split-brain";
+    unfence-peer "/usr/libexec/kubernetes/kubelet-plugins/volume/exec/ctriple.cn~drbd/drbd unfence-peer";
+  }
+}